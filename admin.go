@@ -0,0 +1,406 @@
+package digger
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/proxy"
+	"github.com/spencer404/go-digger/storage"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 管理接口, 请求与响应均为JSON, 出错时返回{"error": "..."}
+//
+//	GET  /status             运行状态
+//	POST /pause              暂停弹出URL
+//	POST /resume             恢复弹出URL
+//	POST /parallels          调整并发数, {"parallels": 4}
+//	POST /interval           调整请求间隔, {"interval": "500ms"}
+//	POST /urls               添加URL, {"urls": ["..."], "priority": 0}
+//	GET  /urls?key=          查询URL状态, key为URL指纹
+//	GET  /proxies            列出冻结与禁用的代理, 代理地址已隐藏密码
+//	POST /proxies/forbid     禁用代理, {"url": "...", "duration": "30m"}
+//	POST /proxies/unfreeze   解冻代理, {"url": "..."}
+//	GET  /bucket             列出Bucket的key
+//	GET  /bucket?key=        读取Bucket的值
+func (r *Reactor) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", r.adminStatus)
+	mux.HandleFunc("/pause", r.adminPause)
+	mux.HandleFunc("/resume", r.adminResume)
+	mux.HandleFunc("/parallels", r.adminParallels)
+	mux.HandleFunc("/interval", r.adminInterval)
+	mux.HandleFunc("/urls", r.adminURLs)
+	mux.HandleFunc("/proxies", r.adminProxies)
+	mux.HandleFunc("/proxies/forbid", r.adminForbid)
+	mux.HandleFunc("/proxies/unfreeze", r.adminUnfreeze)
+	mux.HandleFunc("/bucket", r.adminBucket)
+	return mux
+}
+
+// 管理接口中的时长, 以字符串表示, 如"1m30s"
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "时长应为字符串")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrapf(err, "时长格式错误: %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, adminError{err.Error()})
+}
+
+// 检查请求方法, 不符时返回405
+func allowMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, errors.Errorf("不支持的请求方法: %s", req.Method))
+	return false
+}
+
+// 解析请求体, 失败时返回400
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "解析请求失败"))
+		return false
+	}
+	return true
+}
+
+var stateNames = map[storage.State]string{
+	storage.StateNotExist:   "not_exist",
+	storage.StateWaiting:    "waiting",
+	storage.StateProcessing: "processing",
+	storage.StateFailed:     "failed",
+}
+
+type adminStatus struct {
+	Paused      bool       `json:"paused"`
+	PausedAt    *time.Time `json:"paused_at,omitempty"` // 暂停的时间, 未暂停时省略
+	Parallels   int        `json:"parallels"`
+	Concurrency int        `json:"concurrency"`
+	InFlight    int        `json:"in_flight"`
+	Interval    duration   `json:"interval"`
+}
+
+func (r *Reactor) adminStatus(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, r.status())
+}
+
+func (r *Reactor) status() adminStatus {
+	r.workerLock.Lock()
+	interval := r.Interval
+	r.workerLock.Unlock()
+	r.inFlightLock.Lock()
+	inFlight := len(r.inFlight)
+	r.inFlightLock.Unlock()
+	status := adminStatus{
+		Parallels:   r.Parallels(),
+		Concurrency: r.Concurrency(),
+		InFlight:    inFlight,
+		Interval:    duration(interval),
+	}
+	if t := r.PausedAt(); !t.IsZero() {
+		status.Paused, status.PausedAt = true, &t
+	}
+	return status
+}
+
+func (r *Reactor) adminPause(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	r.Pause()
+	writeJSON(w, http.StatusOK, r.status())
+}
+
+func (r *Reactor) adminResume(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	r.Resume()
+	writeJSON(w, http.StatusOK, r.status())
+}
+
+func (r *Reactor) adminParallels(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	var body struct {
+		Parallels int `json:"parallels"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	if err := r.SetParallels(body.Parallels); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, r.status())
+}
+
+func (r *Reactor) adminInterval(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	var body struct {
+		Interval *duration `json:"interval"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	if body.Interval == nil || *body.Interval < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("interval不能为空或负数"))
+		return
+	}
+	r.SetInterval(time.Duration(*body.Interval))
+	writeJSON(w, http.StatusOK, r.status())
+}
+
+func (r *Reactor) adminURLs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			writeError(w, http.StatusBadRequest, errors.New("key不能为空"))
+			return
+		}
+		state, err := r.Queue.Lookup(key)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, "查询URL失败"))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"key": key, "state": stateNames[state]})
+	case http.MethodPost:
+		var body struct {
+			URLs     []string         `json:"urls"`
+			Priority storage.Priority `json:"priority"`
+		}
+		if !readJSON(w, req, &body) {
+			return
+		}
+		added := 0
+		for _, url := range body.URLs {
+			ok, err := r.Queue.Add(url, body.Priority)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, errors.Wrapf(err, "添加%q失败, 已添加%d个", url, added))
+				return
+			}
+			if ok {
+				added++
+			}
+		}
+		r.log.Info("已通过管理接口添加URL", "count", added)
+		writeJSON(w, http.StatusOK, map[string]int{"added": added})
+	default:
+		allowMethod(w, req, http.MethodGet)
+	}
+}
+
+type adminFrozenProxy struct {
+	URL   string    `json:"url"`
+	Until time.Time `json:"until"` // 解冻时间
+	Count int       `json:"count"` // 被冻结的数量, 每个代理最多有ProxyParallels个
+}
+
+type adminProxyStats struct {
+	URL      string    `json:"url"`
+	Success  int       `json:"success"`
+	Failure  int       `json:"failure"`
+	Bans     int       `json:"bans"`
+	Latency  duration  `json:"latency"`
+	Score    float64   `json:"score"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+type adminProxies struct {
+	Frozen      []adminFrozenProxy `json:"frozen"`
+	Blacklisted []string           `json:"blacklisted"`
+	Stats       []adminProxyStats  `json:"stats"` // 按得分从高到低排列
+}
+
+func (r *Reactor) adminProxies(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	result := adminProxies{
+		Frozen:      make([]adminFrozenProxy, 0),
+		Blacklisted: make([]string, 0),
+		Stats:       make([]adminProxyStats, 0),
+	}
+	// 代理地址均隐藏密码
+	for _, url := range r.blackList.GetKeys() {
+		result.Blacklisted = append(result.Blacklisted, proxy.Redact(url))
+	}
+	for url, s := range r.ProxyStats() {
+		result.Stats = append(result.Stats, adminProxyStats{
+			URL:      proxy.Redact(url),
+			Success:  s.Success,
+			Failure:  s.Failure,
+			Bans:     s.Bans,
+			Latency:  duration(s.Latency),
+			Score:    s.Score(),
+			LastUsed: s.LastUsed,
+		})
+	}
+	sort.Slice(result.Stats, func(i, j int) bool { return result.Stats[i].Score > result.Stats[j].Score })
+	r.freezeLock.Lock()
+	for url, item := range r.freezeList {
+		result.Frozen = append(result.Frozen, adminFrozenProxy{URL: proxy.Redact(url), Until: item.t, Count: len(item.ps)})
+	}
+	r.freezeLock.Unlock()
+	sort.Slice(result.Frozen, func(i, j int) bool { return result.Frozen[i].URL < result.Frozen[j].URL })
+	sort.Strings(result.Blacklisted)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (r *Reactor) adminForbid(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	var body struct {
+		URL      string   `json:"url"`
+		Duration duration `json:"duration"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	if body.URL == "" || body.Duration <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("url与duration不能为空"))
+		return
+	}
+	url, exist := matchProxyURL(body.URL, r.knownProxyURLs())
+	if !exist {
+		writeError(w, http.StatusNotFound, errors.Errorf("代理不存在: %s", proxy.Redact(body.URL)))
+		return
+	}
+	// 已在代理池中的代理在被获取时丢弃
+	r.blackList.SetWithTTL(url, struct{}{}, time.Duration(body.Duration))
+	r.clients.Remove(url)
+	r.pool.forget(url)
+	r.limiter.forget(proxyKey(url))
+	r.log.Info("已通过管理接口禁用代理", "proxy", proxy.Redact(url), "duration", time.Duration(body.Duration))
+	writeJSON(w, http.StatusOK, map[string]string{"url": proxy.Redact(url)})
+}
+
+// 代理池, 暂存区, 冻结列表中与正在使用的代理地址
+func (r *Reactor) knownProxyURLs() []string {
+	urls := make([]string, 0)
+	for _, key := range r.poolFilter.ToSlice() {
+		// key为"url:index"
+		if s, ok := key.(string); ok {
+			if i := strings.LastIndex(s, ":"); i > 0 {
+				urls = append(urls, s[:i])
+			}
+		}
+	}
+	r.freezeLock.Lock()
+	for url := range r.freezeList {
+		urls = append(urls, url)
+	}
+	r.freezeLock.Unlock()
+	return urls
+}
+
+// 在urls中查找s, s可为/proxies返回的已隐藏密码的地址
+func matchProxyURL(s string, urls []string) (string, bool) {
+	for _, url := range urls {
+		if url == s {
+			return url, true
+		}
+	}
+	for _, url := range urls {
+		if proxy.Redact(url) == s {
+			return url, true
+		}
+	}
+	return "", false
+}
+
+func (r *Reactor) adminUnfreeze(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	var body struct {
+		URL string `json:"url"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	r.freezeLock.Lock()
+	urls := make([]string, 0, len(r.freezeList))
+	for url := range r.freezeList {
+		urls = append(urls, url)
+	}
+	url, exist := matchProxyURL(body.URL, urls)
+	item := r.freezeList[url]
+	delete(r.freezeList, url)
+	r.freezeLock.Unlock()
+	if !exist {
+		writeError(w, http.StatusNotFound, errors.Errorf("代理未被冻结: %s", proxy.Redact(body.URL)))
+		return
+	}
+	r.putBackLock.Lock()
+	r.putBackList = append(r.putBackList, item.ps...)
+	r.putBackLock.Unlock()
+	r.log.Info("已通过管理接口解冻代理", "proxy", proxy.Redact(url))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"url": proxy.Redact(url), "count": len(item.ps)})
+}
+
+func (r *Reactor) adminBucket(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	key := req.URL.Query().Get("key")
+	if key == "" {
+		keys, err := r.Bucket.Keys()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, "获取Bucket的key失败"))
+			return
+		}
+		if keys == nil {
+			keys = make([]string, 0)
+		}
+		sort.Strings(keys)
+		writeJSON(w, http.StatusOK, map[string][]string{"keys": keys})
+		return
+	}
+	value, err := r.Bucket.Get(key)
+	if err == storage.ErrNotExist {
+		writeError(w, http.StatusNotFound, errors.Errorf("key不存在: %s", key))
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrapf(err, "读取%q失败", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"key": key, "value": value})
+}
@@ -0,0 +1,189 @@
+package digger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// 创建连接某个代理的http.Client, proxyURL为空时直连
+// 同一代理的全部请求共用返回的http.Client, 其连接, TLS会话与Cookie在URL之间复用
+type ClientFactory func(proxyURL string) (*http.Client, error)
+
+// 证书校验策略
+type TLSVerify int
+
+const (
+	TLSVerifyAll    TLSVerify = iota // 全部校验, 默认
+	TLSVerifyDirect                  // 仅校验直连, 使用代理时不校验
+	TLSVerifyNone                    // 全部不校验
+)
+
+// 默认ClientFactory使用的连接参数, 零值字段使用默认值
+type TransportOpt struct {
+	Timeout             time.Duration     // 单个请求的超时时间, 默认60秒, 为负数时不限制
+	DialTimeout         time.Duration     // 建立连接的超时时间, 默认30秒, 为负数时不限制
+	TLSHandshakeTimeout time.Duration     // TLS握手的超时时间, 默认10秒
+	IdleConnTimeout     time.Duration     // 空闲连接的保持时间, 默认90秒
+	MaxIdleConns        int               // 全部主机的最大空闲连接数, 默认100
+	MaxIdleConnsPerHost int               // 每个主机的最大空闲连接数, 默认为GOMAXPROCS+1
+	DisableHTTP2        bool              // 不尝试使用HTTP/2
+	DisableCookies      bool              // 不保存Cookie
+	TLSConfig           *tls.Config       // 基础TLS配置, 以下字段会覆盖其中对应的设置
+	TLSVerify           TLSVerify         // 证书校验策略
+	RootCAs             *x509.CertPool    // 校验服务端证书使用的CA, 为nil时使用系统CA
+	Certificates        []tls.Certificate // 客户端证书
+}
+
+func (o TransportOpt) withDefaults() TransportOpt {
+	if o.Timeout == 0 {
+		o.Timeout = time.Minute
+	} else if o.Timeout < 0 {
+		o.Timeout = 0
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = time.Second * 30
+	} else if o.DialTimeout < 0 {
+		o.DialTimeout = 0
+	}
+	if o.TLSHandshakeTimeout == 0 {
+		o.TLSHandshakeTimeout = time.Second * 10
+	}
+	if o.IdleConnTimeout == 0 {
+		o.IdleConnTimeout = time.Second * 90
+	}
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = 100
+	}
+	if o.MaxIdleConnsPerHost == 0 {
+		o.MaxIdleConnsPerHost = runtime.GOMAXPROCS(0) + 1
+	}
+	return o
+}
+
+// 直连与使用代理时的TLS配置
+func (o TransportOpt) tlsConfig(withProxy bool) *tls.Config {
+	config := &tls.Config{}
+	if o.TLSConfig != nil {
+		config = o.TLSConfig.Clone()
+	}
+	if o.RootCAs != nil {
+		config.RootCAs = o.RootCAs
+	}
+	if len(o.Certificates) > 0 {
+		config.Certificates = o.Certificates
+	}
+	switch o.TLSVerify {
+	case TLSVerifyDirect:
+		config.InsecureSkipVerify = withProxy
+	case TLSVerifyNone:
+		config.InsecureSkipVerify = true
+	}
+	return config
+}
+
+// 读取PEM格式的CA证书, 添加到系统CA中
+func loadCAs(files ...string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, file := range files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取CA证书%q失败", file)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("CA证书%q中没有有效的证书", file)
+		}
+	}
+	return pool, nil
+}
+
+// 按TransportOpt创建http.Client的ClientFactory
+func NewClientFactory(opt TransportOpt) ClientFactory {
+	opt = opt.withDefaults()
+	return func(proxyURL string) (*http.Client, error) {
+		transport := &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   opt.DialTimeout,
+				KeepAlive: time.Second * 30,
+			}).DialContext,
+			TLSHandshakeTimeout:   opt.TLSHandshakeTimeout,
+			IdleConnTimeout:       opt.IdleConnTimeout,
+			MaxIdleConns:          opt.MaxIdleConns,
+			MaxIdleConnsPerHost:   opt.MaxIdleConnsPerHost,
+			ExpectContinueTimeout: time.Second,
+			ForceAttemptHTTP2:     !opt.DisableHTTP2,
+		}
+		transport.TLSClientConfig = opt.tlsConfig(proxyURL != "")
+		if proxyURL != "" {
+			u, err := url.Parse(proxyURL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "代理地址格式错误: %s", proxyURL)
+			}
+			transport.Proxy = http.ProxyURL(u)
+		}
+		client := &http.Client{Transport: transport, Timeout: opt.Timeout}
+		if !opt.DisableCookies {
+			jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+			if err != nil {
+				return nil, errors.Wrap(err, "创建CookieJar失败")
+			}
+			client.Jar = jar
+		}
+		return client, nil
+	}
+}
+
+// 按代理缓存的http.Client
+type clientPool struct {
+	factory ClientFactory
+	clients map[string]*http.Client // 代理地址 -> http.Client, 直连时为""
+	lock    sync.Mutex
+}
+
+func newClientPool(factory ClientFactory) *clientPool {
+	return &clientPool{factory: factory, clients: make(map[string]*http.Client)}
+}
+
+// 获取代理对应的http.Client, 不存在时创建
+func (p *clientPool) Get(proxyURL string) (*http.Client, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if c, exist := p.clients[proxyURL]; exist {
+		return c, nil
+	}
+	c, err := p.factory(proxyURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建http.Client失败")
+	}
+	p.clients[proxyURL] = c
+	return c, nil
+}
+
+// 移除代理对应的http.Client并关闭其空闲连接, 用于代理被删除或禁用时
+func (p *clientPool) Remove(proxyURL string) {
+	p.lock.Lock()
+	c, exist := p.clients[proxyURL]
+	delete(p.clients, proxyURL)
+	p.lock.Unlock()
+	if exist {
+		c.CloseIdleConnections()
+	}
+}
+
+func (p *clientPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.clients)
+}
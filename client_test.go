@@ -0,0 +1,229 @@
+package digger
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/storage"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientPool(t *testing.T) {
+	calls := 0
+	pool := newClientPool(func(proxyURL string) (*http.Client, error) {
+		calls++
+		if proxyURL == "bad" {
+			return nil, errors.New("格式错误")
+		}
+		return &http.Client{}, nil
+	})
+	// 同一代理共用
+	c1, err := pool.Get("")
+	assert.Nil(t, err)
+	c2, err := pool.Get("")
+	assert.Nil(t, err)
+	assert.True(t, c1 == c2)
+	c3, err := pool.Get("http://127.0.0.1:1")
+	assert.Nil(t, err)
+	assert.True(t, c1 != c3)
+	assert.Equal(t, 2, calls)
+	// 移除后重新创建
+	pool.Remove("http://127.0.0.1:1")
+	c4, err := pool.Get("http://127.0.0.1:1")
+	assert.Nil(t, err)
+	assert.True(t, c3 != c4)
+	// 创建失败时不缓存
+	_, err = pool.Get("bad")
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, pool.Len())
+}
+
+func TestNewClientFactory(t *testing.T) {
+	factory := NewClientFactory(TransportOpt{Timeout: time.Second, DisableHTTP2: true, DisableCookies: true})
+	c, err := factory("")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, c.Timeout)
+	assert.Nil(t, c.Jar)
+	transport := c.Transport.(*http.Transport)
+	assert.Nil(t, transport.Proxy)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	// 使用代理
+	c, err = NewClientFactory(TransportOpt{})("http://127.0.0.1:1")
+	assert.Nil(t, err)
+	assert.NotNil(t, c.Jar)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	u, err := c.Transport.(*http.Transport).Proxy(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:1", u.Host)
+	_, err = factory("://")
+	assert.NotNil(t, err)
+}
+
+func TestReactor_Run_ClientReuse(t *testing.T) {
+	// 同一Run中的URL共用Cookie与连接
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := req.Cookie("session"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+			_, _ = w.Write([]byte("new"))
+			return
+		}
+		_, _ = w.Write([]byte("reused"))
+	}))
+	defer server.Close()
+	calls := 0
+	factory := NewClientFactory(TransportOpt{})
+	r := newTestReactor(t, 1, NewReactorOpt().ClientFactory(func(proxyURL string) (*http.Client, error) {
+		calls++
+		return factory(proxyURL)
+	}))
+	var lock sync.Mutex
+	bodies := make([]string, 0)
+	spider := &Spider{
+		Seeders: []string{server.URL + "/a", server.URL + "/b", server.URL + "/c"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			resp, err := client.R().Get(item.URL)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			bodies = append(bodies, resp.String())
+			lock.Unlock()
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{"new", "reused", "reused"}, bodies)
+}
+
+// 生成自签名的客户端证书, 返回证书与私钥的文件路径
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "digger"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+// 按opt创建Reactor, 并使用其直连的http.Client请求url
+func getWithReactor(t *testing.T, opt *ReactorOpt, url string) error {
+	r := newTestReactor(t, 1, opt)
+	c, err := r.clients.Get("")
+	assert.Nil(t, err)
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestReactor_TLS(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(time.Millisecond * 500)
+		}
+		if req.URL.Path == "/cert" && len(req.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	// 默认校验证书
+	assert.NotNil(t, getWithReactor(t, NewReactorOpt(), server.URL))
+	assert.Nil(t, getWithReactor(t, NewReactorOpt().TLSVerify(TLSVerifyNone), server.URL))
+	// 仅校验直连
+	assert.NotNil(t, getWithReactor(t, NewReactorOpt().TLSVerify(TLSVerifyDirect), server.URL))
+	// 信任自定义CA
+	assert.Nil(t, getWithReactor(t, NewReactorOpt().CACertFiles(caFile), server.URL))
+	_, err := NewReactor(storage.NewMemQueue(newMemStore(), time.Minute), newMemStore(), 1,
+		NewReactorOpt().CACertFiles(filepath.Join(dir, "none.pem")))
+	assert.NotNil(t, err)
+	// 客户端证书
+	certFile, keyFile := writeClientCert(t, dir)
+	r := newTestReactor(t, 1, NewReactorOpt().CACertFiles(caFile).ClientCert(certFile, keyFile))
+	c, err := r.clients.Get("")
+	assert.Nil(t, err)
+	resp, err := c.Get(server.URL + "/cert")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Nil(t, resp.Body.Close())
+	c, err = newTestReactor(t, 1, NewReactorOpt().CACertFiles(caFile)).clients.Get("")
+	assert.Nil(t, err)
+	resp, err = c.Get(server.URL + "/cert")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Nil(t, resp.Body.Close())
+	// 超时
+	assert.NotNil(t, getWithReactor(t, NewReactorOpt().TLSVerify(TLSVerifyNone).Timeout(time.Millisecond*100), server.URL+"/slow"))
+	assert.Nil(t, getWithReactor(t, NewReactorOpt().TLSVerify(TLSVerifyNone).Timeout(-1), server.URL+"/slow"))
+}
+
+func TestTransportOpt_tlsConfig(t *testing.T) {
+	base := &tls.Config{ServerName: "a.com"}
+	opt := TransportOpt{TLSConfig: base, TLSVerify: TLSVerifyDirect}
+	assert.False(t, opt.tlsConfig(false).InsecureSkipVerify)
+	assert.True(t, opt.tlsConfig(true).InsecureSkipVerify)
+	assert.Equal(t, "a.com", opt.tlsConfig(true).ServerName)
+	// 不修改原配置
+	assert.False(t, base.InsecureSkipVerify)
+}
+
+// 每个请求新建resty.Client, 无法复用TLS连接
+func BenchmarkClient_New(b *testing.B) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := resty.New().SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+		if _, err := c.R().Get(server.URL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 从clientPool获取http.Client, 复用连接
+func BenchmarkClient_Pool(b *testing.B) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	pool := newClientPool(NewClientFactory(TransportOpt{TLSConfig: &tls.Config{InsecureSkipVerify: true}}))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hc, err := pool.Get("")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := resty.NewWithClient(hc).R().Get(server.URL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package digger
+
+import (
+	"context"
+	"sync"
+)
+
+// 自适应并发控制, 加性增, 乘性减(AIMD)
+// 连续limit次成功后并发数加1; 失败时减半, 减半后需再观察limit次结果才会再次减半, 避免同一批失败反复降低
+type aimd struct {
+	min, max      int
+	limit         int           // 当前允许同时处理的URL数量
+	active        int           // 正在处理的URL数量
+	successes     int           // 上次调整后连续成功的次数
+	sinceDecrease int           // 上次减半后观察到的结果数
+	changed       chan struct{} // limit或active变化时关闭并重建, 用于唤醒等待者
+	lock          sync.Mutex
+}
+
+func newAIMD(min, max, initial int) *aimd {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	if initial < min {
+		initial = min
+	} else if initial > max {
+		initial = max
+	}
+	return &aimd{min: min, max: max, limit: initial, sinceDecrease: initial, changed: make(chan struct{})}
+}
+
+// 调用方需持有锁
+func (a *aimd) notify() {
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// 等待空闲名额, ctx被取消或done被关闭时返回false; a为nil时不限制
+func (a *aimd) acquire(ctx context.Context, done <-chan struct{}) bool {
+	if a == nil {
+		return true
+	}
+	for {
+		a.lock.Lock()
+		if a.active < a.limit {
+			a.active++
+			a.lock.Unlock()
+			return true
+		}
+		changed := a.changed
+		a.lock.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
+
+func (a *aimd) release() {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	a.active--
+	a.notify()
+	a.lock.Unlock()
+}
+
+// 记录一次处理结果并调整并发数
+func (a *aimd) record(ok bool) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.sinceDecrease++
+	if ok {
+		a.successes++
+		if a.successes >= a.limit && a.limit < a.max {
+			a.limit++
+			a.successes = 0
+			a.notify()
+		}
+		return
+	}
+	a.successes = 0
+	if a.sinceDecrease < a.limit {
+		return
+	}
+	a.sinceDecrease = 0
+	if limit := a.limit / 2; limit >= a.min {
+		a.limit = limit
+	} else {
+		a.limit = a.min
+	}
+}
+
+// 手动设置并发数, 限制在[min, max]内
+func (a *aimd) setLimit(n int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if n < a.min {
+		n = a.min
+	} else if n > a.max {
+		n = a.max
+	}
+	a.limit = n
+	a.successes = 0
+	a.notify()
+}
+
+func (a *aimd) Limit() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.limit
+}
@@ -0,0 +1,114 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/storage"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAIMD_Record(t *testing.T) {
+	a := newAIMD(2, 8, 4)
+	// 连续limit次成功后加1
+	for i := 0; i < 3; i++ {
+		a.record(true)
+	}
+	assert.Equal(t, 4, a.Limit())
+	a.record(true)
+	assert.Equal(t, 5, a.Limit())
+	// 失败时减半, 冷却期内不再减半
+	a.record(false)
+	assert.Equal(t, 2, a.Limit())
+	a.record(false)
+	assert.Equal(t, 2, a.Limit())
+	// 不低于min
+	a.record(false)
+	a.record(false)
+	assert.Equal(t, 2, a.Limit())
+	// 不超过max
+	for i := 0; i < 100; i++ {
+		a.record(true)
+	}
+	assert.Equal(t, 8, a.Limit())
+	// 初始值被限制在范围内
+	assert.Equal(t, 3, newAIMD(3, 5, 1).Limit())
+	assert.Equal(t, 5, newAIMD(3, 5, 10).Limit())
+}
+
+func TestAIMD_Acquire(t *testing.T) {
+	a := newAIMD(1, 2, 1)
+	ctx := context.Background()
+	assert.True(t, a.acquire(ctx, nil))
+	// 名额已满, 释放后才能获得
+	acquired := make(chan bool)
+	go func() {
+		acquired <- a.acquire(ctx, nil)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("名额已满时不应获得")
+	case <-time.After(time.Millisecond * 50):
+	}
+	a.release()
+	assert.True(t, <-acquired)
+	// 提高并发数后可立即获得
+	a.record(true)
+	assert.Equal(t, 2, a.Limit())
+	assert.True(t, a.acquire(ctx, nil))
+	// 取消或结束时返回false
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, a.acquire(cctx, nil))
+	done := make(chan struct{})
+	close(done)
+	assert.False(t, a.acquire(ctx, done))
+	// 未开启时不限制
+	var none *aimd
+	assert.True(t, none.acquire(ctx, nil))
+	none.release()
+	none.record(false)
+}
+
+func TestReactor_Run_AdaptiveConcurrency(t *testing.T) {
+	r := newTestReactor(t, 2, NewReactorOpt().AdaptiveConcurrency(1, 3).RetryBackoff(time.Millisecond, time.Millisecond))
+	assert.Equal(t, 2, r.Concurrency())
+	seeders := make([]string, 30)
+	for i := range seeders {
+		seeders[i] = fmt.Sprintf("s%d", i)
+	}
+	var lock sync.Mutex
+	active, maxActive := 0, 0
+	processed := make(map[string]bool)
+	spider := &Spider{
+		Seeders: seeders,
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			lock.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			lock.Unlock()
+			time.Sleep(time.Millisecond * 10)
+			lock.Lock()
+			active--
+			lock.Unlock()
+			// 前10个URL首次失败
+			if item.Attempts == 0 && item.ID <= 10 {
+				return errors.New("被拒绝")
+			}
+			lock.Lock()
+			processed[item.URL] = true
+			lock.Unlock()
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Len(t, processed, len(seeders))
+	assert.True(t, maxActive <= 3, maxActive)
+	assert.True(t, r.Concurrency() >= 1 && r.Concurrency() <= 3, r.Concurrency())
+}
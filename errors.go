@@ -0,0 +1,100 @@
+package digger
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/storage"
+	"time"
+)
+
+// OnProcess返回的错误决定URL与代理的去向, Reactor使用errors.Is/As识别, 可被errors.Wrap包装
+// 未设置ProxyHelper的Flag时, 由错误决定代理的去向; 已设置时以Flag为准
+//
+//   错误             URL                           代理
+//   nil              完成                          放回
+//   ErrSkip          完成, 不再重试                放回
+//   RetryLater       d后重试, 计入失败次数         放回
+//   Requeue          以新优先级重试, 计入失败次数  放回
+//   ProxyBanned      立即放回队列, 不计失败次数    禁用d
+//   Fatal            放回队列, 中止爬虫            放回
+//   其他             退避后重试, 计入失败次数      删除
+
+// 跳过当前URL, 视为已完成
+var ErrSkip = errors.New("跳过URL")
+
+// 稍后重试
+type RetryLaterError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *RetryLaterError) Error() string {
+	return "稍后重试: " + errorString(e.Err)
+}
+
+func (e *RetryLaterError) Cause() error  { return e.Err }
+func (e *RetryLaterError) Unwrap() error { return e.Err }
+
+// d后重试, 代替默认的退避时间
+func RetryLater(d time.Duration, err error) error {
+	return &RetryLaterError{Delay: d, Err: err}
+}
+
+// 以新的优先级重试
+type RequeueError struct {
+	Priority storage.Priority
+	Err      error
+}
+
+func (e *RequeueError) Error() string {
+	return "重新入队: " + errorString(e.Err)
+}
+
+func (e *RequeueError) Cause() error  { return e.Err }
+func (e *RequeueError) Unwrap() error { return e.Err }
+
+// 以priority重试, 通常用于降低优先级
+func Requeue(priority storage.Priority, err error) error {
+	return &RequeueError{Priority: priority, Err: err}
+}
+
+// 代理被目标网站封禁
+type ProxyBannedError struct {
+	Duration time.Duration
+	Err      error
+}
+
+func (e *ProxyBannedError) Error() string {
+	return "代理被封禁: " + errorString(e.Err)
+}
+
+func (e *ProxyBannedError) Cause() error  { return e.Err }
+func (e *ProxyBannedError) Unwrap() error { return e.Err }
+
+// 禁用当前代理d, URL换用其他代理重试; 未使用代理时按普通错误处理
+func ProxyBanned(d time.Duration, err error) error {
+	return &ProxyBannedError{Duration: d, Err: err}
+}
+
+// 无法继续爬取
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string {
+	return "致命错误: " + errorString(e.Err)
+}
+
+func (e *FatalError) Cause() error  { return e.Err }
+func (e *FatalError) Unwrap() error { return e.Err }
+
+// 中止爬虫, Run返回该错误
+func Fatal(err error) error {
+	return &FatalError{Err: err}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
@@ -0,0 +1,214 @@
+package digger
+
+import (
+	"context"
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryAfter = time.Second * 10 // 429未携带Retry-After时的暂停时间
+	slowdownRecovery  = time.Minute      // 降速后持续该时间未收到429, 恢复配置的速率
+	slowdownMinFactor = 16               // 最多降为配置速率的1/16
+)
+
+// 限速规则, 每秒limit个请求, 最多突发burst个
+type rateRule struct {
+	limit rate.Limit
+	burst int
+}
+
+// burst为0时令牌桶永远无法放行, 故至少为1
+func newRateRule(rps float64, burst int) rateRule {
+	if burst < 1 {
+		burst = 1
+	}
+	return rateRule{limit: rate.Limit(rps), burst: burst}
+}
+
+// 某个主机或代理的令牌桶
+type rateBucket struct {
+	limiter     *rate.Limiter
+	base        rate.Limit // 配置的速率
+	pausedUntil time.Time  // 收到429后暂停到该时间
+	slowed      time.Time  // 最近一次降速的时间
+}
+
+// 按主机与代理分别限速的令牌桶
+type rateLimiter struct {
+	hostRules map[string]rateRule // 主机 -> 规则, "*"为默认规则
+	proxyRule *rateRule           // 每个代理的规则
+	buckets   map[string]*rateBucket
+	lock      sync.Mutex
+}
+
+func newRateLimiter(hostRules map[string]rateRule, proxyRule *rateRule) *rateLimiter {
+	if hostRules == nil {
+		hostRules = make(map[string]rateRule)
+	}
+	return &rateLimiter{
+		hostRules: hostRules,
+		proxyRule: proxyRule,
+		buckets:   make(map[string]*rateBucket),
+	}
+}
+
+func hostKey(host string) string   { return "host:" + host }
+func proxyKey(proxy string) string { return "proxy:" + proxy }
+
+// URL的主机名, 解析失败时为空
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+func (l *rateLimiter) rule(key string) *rateRule {
+	if strings.HasPrefix(key, "proxy:") {
+		return l.proxyRule
+	}
+	if rule, exist := l.hostRules[strings.TrimPrefix(key, "host:")]; exist {
+		return &rule
+	}
+	if rule, exist := l.hostRules["*"]; exist {
+		return &rule
+	}
+	return nil
+}
+
+// 获取令牌桶, 未配置规则的key不限速, 仅在降速时创建; 调用方需持有锁
+func (l *rateLimiter) bucket(key string, create bool) *rateBucket {
+	if b, exist := l.buckets[key]; exist {
+		return b
+	}
+	rule := l.rule(key)
+	if rule == nil && !create {
+		return nil
+	}
+	b := newRateBucket(rule)
+	l.buckets[key] = b
+	return b
+}
+
+// 按规则创建令牌桶, rule为nil时不限速
+func newRateBucket(rule *rateRule) *rateBucket {
+	if rule == nil {
+		return &rateBucket{limiter: rate.NewLimiter(rate.Inf, 1), base: rate.Inf}
+	}
+	return &rateBucket{limiter: rate.NewLimiter(rule.limit, rule.burst), base: rule.limit}
+}
+
+// 替换默认规则("*"), rule为nil时取消; 已创建的使用默认规则的令牌桶将按新规则重建
+// 重建时保留429导致的暂停与降速
+func (l *rateLimiter) SetDefault(rule *rateRule) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if rule == nil {
+		delete(l.hostRules, "*")
+	} else {
+		l.hostRules["*"] = *rule
+	}
+	now := time.Now()
+	for key, old := range l.buckets {
+		if !strings.HasPrefix(key, "host:") {
+			continue
+		}
+		if _, exist := l.hostRules[strings.TrimPrefix(key, "host:")]; exist {
+			continue
+		}
+		paused := now.Before(old.pausedUntil)
+		slowed := old.limiter.Limit() < old.base && now.Sub(old.slowed) <= slowdownRecovery
+		if rule == nil && !paused {
+			delete(l.buckets, key)
+			continue
+		}
+		b := newRateBucket(rule)
+		b.pausedUntil, b.slowed = old.pausedUntil, old.slowed
+		if slowed && b.base != rate.Inf {
+			// 按原降速比例降低新速率
+			b.limiter.SetLimit(b.base * (old.limiter.Limit() / old.base))
+		}
+		l.buckets[key] = b
+	}
+}
+
+// 移除key的令牌桶, 用于不再使用的代理
+func (l *rateLimiter) forget(key string) {
+	l.lock.Lock()
+	delete(l.buckets, key)
+	l.lock.Unlock()
+}
+
+// 等待主机的令牌, 应在获取代理之前调用, 以免等待期间占用代理
+func (l *rateLimiter) WaitHost(ctx context.Context, host string) error {
+	return l.wait(ctx, hostKey(host))
+}
+
+// 等待代理的令牌, 应在获取代理之后调用
+func (l *rateLimiter) WaitProxy(ctx context.Context, proxy string) error {
+	return l.wait(ctx, proxyKey(proxy))
+}
+
+func (l *rateLimiter) wait(ctx context.Context, key string) error {
+	for {
+		l.lock.Lock()
+		b := l.bucket(key, false)
+		if b == nil {
+			l.lock.Unlock()
+			return nil
+		}
+		now := time.Now()
+		if now.Before(b.pausedUntil) {
+			d := b.pausedUntil.Sub(now)
+			l.lock.Unlock()
+			if !sleepContext(ctx, d) {
+				return ctx.Err()
+			}
+			continue
+		}
+		if b.limiter.Limit() < b.base && now.Sub(b.slowed) > slowdownRecovery {
+			b.limiter.SetLimit(b.base)
+		}
+		limiter := b.limiter
+		l.lock.Unlock()
+		return limiter.Wait(ctx)
+	}
+}
+
+// 暂停key对应的主机或代理d, 并将速率减半
+func (l *rateLimiter) Slowdown(key string, d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	b := l.bucket(key, true)
+	now := time.Now()
+	if until := now.Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	if limit := b.limiter.Limit() / 2; limit >= b.base/slowdownMinFactor {
+		b.limiter.SetLimit(limit)
+	}
+	b.slowed = now
+}
+
+// 解析Retry-After, 支持秒数与HTTP日期, 无法解析时返回defaultRetryAfter
+func retryAfter(resp *resty.Response) time.Duration {
+	v := resp.Header().Get("Retry-After")
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Second * time.Duration(seconds)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,103 @@
+package digger
+
+import (
+	"context"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	l := newRateLimiter(map[string]rateRule{
+		"a.com": {limit: 20, burst: 2},
+		"*":     {limit: rate.Inf, burst: 1},
+	}, &rateRule{limit: 10, burst: 1})
+	ctx := context.Background()
+	// 突发2个, 之后每50ms一个
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, l.WaitHost(ctx, "a.com"))
+	}
+	assert.True(t, time.Since(start) >= time.Millisecond*190, time.Since(start))
+	// 默认规则不限速
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, l.WaitHost(ctx, "b.com"))
+	}
+	assert.True(t, time.Since(start) < time.Millisecond*50, time.Since(start))
+	// 每个代理单独限速
+	start = time.Now()
+	assert.Nil(t, l.WaitProxy(ctx, "http://p1"))
+	assert.Nil(t, l.WaitProxy(ctx, "http://p2"))
+	assert.True(t, time.Since(start) < time.Millisecond*50, time.Since(start))
+	assert.Nil(t, l.WaitProxy(ctx, "http://p1"))
+	assert.True(t, time.Since(start) >= time.Millisecond*90, time.Since(start))
+	// 取消时返回
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NotNil(t, l.WaitHost(cctx, "a.com"))
+}
+
+func TestRateLimiter_Slowdown(t *testing.T) {
+	l := newRateLimiter(map[string]rateRule{"a.com": {limit: 100, burst: 1}}, nil)
+	ctx := context.Background()
+	// 未配置规则的主机也可被暂停
+	l.Slowdown(hostKey("b.com"), time.Millisecond*100)
+	start := time.Now()
+	assert.Nil(t, l.WaitHost(ctx, "b.com"))
+	assert.True(t, time.Since(start) >= time.Millisecond*90, time.Since(start))
+	// 降速后速率减半, 最低为配置的1/16
+	for i := 0; i < 10; i++ {
+		l.Slowdown(hostKey("a.com"), 0)
+	}
+	assert.Equal(t, rate.Limit(100)/slowdownMinFactor, l.buckets[hostKey("a.com")].limiter.Limit())
+}
+
+func TestRateLimiter_SetDefault(t *testing.T) {
+	l := newRateLimiter(nil, nil)
+	ctx := context.Background()
+	assert.Nil(t, l.WaitHost(ctx, "a.com"))
+	// 被暂停的主机在替换默认规则后仍被暂停
+	l.Slowdown(hostKey("a.com"), time.Millisecond*100)
+	rule := newRateRule(100, 0)
+	l.SetDefault(&rule)
+	assert.Equal(t, 1, l.buckets[hostKey("a.com")].limiter.Burst())
+	start := time.Now()
+	assert.Nil(t, l.WaitHost(ctx, "a.com"))
+	assert.True(t, time.Since(start) >= time.Millisecond*90, time.Since(start))
+	// 取消默认规则后, 未被暂停的主机不再限速
+	l.SetDefault(nil)
+	assert.NotContains(t, l.buckets, hostKey("a.com"))
+	// 移除代理的令牌桶
+	l.Slowdown(proxyKey("http://p1"), 0)
+	l.forget(proxyKey("http://p1"))
+	assert.NotContains(t, l.buckets, proxyKey("http://p1"))
+}
+
+func TestRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/seconds":
+			w.Header().Set("Retry-After", "3")
+		case "/date":
+			w.Header().Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	c := resty.New()
+	resp, err := c.R().Get(server.URL + "/seconds")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second*3, retryAfter(resp))
+	resp, err = c.R().Get(server.URL + "/date")
+	assert.Nil(t, err)
+	d := retryAfter(resp)
+	assert.True(t, d > time.Second*55 && d <= time.Minute, d)
+	resp, err = c.R().Get(server.URL + "/none")
+	assert.Nil(t, err)
+	assert.Equal(t, defaultRetryAfter, retryAfter(resp))
+}
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 日志等级
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// 支持等级与结构化字段的日志接口
+// kv为交替的键值对, 如Info("正在执行", "url", u, "worker", 1)
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+	With(kv ...interface{}) Logger // 返回附带kv字段的Logger
+}
+
+var (
+	defaultLogger Logger = NewStd(log.New(os.Stderr, "", log.LstdFlags), LevelInfo)
+	defaultLock   sync.RWMutex
+)
+
+// 默认Logger, 未单独设置Logger的Reactor与代理Provider使用
+func Default() Logger {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultLogger
+}
+
+func SetDefault(l Logger) {
+	defaultLock.Lock()
+	defaultLogger = l
+	defaultLock.Unlock()
+}
+
+// 基于标准库log的Logger, 输出格式: [INFO] msg k1=v1 k2=v2
+type stdLogger struct {
+	l      *log.Logger
+	level  Level
+	fields []interface{}
+}
+
+// 输出level及以上等级的日志到l
+func NewStd(l *log.Logger, level Level) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+func (s *stdLogger) output(level Level, msg string, kv []interface{}) {
+	if level < s.level {
+		return
+	}
+	b := &strings.Builder{}
+	b.WriteString("[" + level.String() + "] " + msg)
+	writeFields(b, s.fields)
+	writeFields(b, kv)
+	_ = s.l.Output(3, b.String())
+}
+
+func writeFields(b *strings.Builder, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 < len(kv) {
+			fmt.Fprintf(b, " %v=%v", kv[i], kv[i+1])
+		} else {
+			fmt.Fprintf(b, " %v=<缺失>", kv[i])
+		}
+	}
+}
+
+func (s *stdLogger) Debug(msg string, kv ...interface{}) { s.output(LevelDebug, msg, kv) }
+func (s *stdLogger) Info(msg string, kv ...interface{})  { s.output(LevelInfo, msg, kv) }
+func (s *stdLogger) Warn(msg string, kv ...interface{})  { s.output(LevelWarn, msg, kv) }
+func (s *stdLogger) Error(msg string, kv ...interface{}) { s.output(LevelError, msg, kv) }
+
+func (s *stdLogger) With(kv ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(s.fields)+len(kv))
+	fields = append(fields, s.fields...)
+	fields = append(fields, kv...)
+	return &stdLogger{l: s.l, level: s.level, fields: fields}
+}
+
+// 丢弃全部日志
+type nopLogger struct{}
+
+func NewNop() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, kv ...interface{}) {}
+func (nopLogger) Info(msg string, kv ...interface{})  {}
+func (nopLogger) Warn(msg string, kv ...interface{})  {}
+func (nopLogger) Error(msg string, kv ...interface{}) {}
+func (n nopLogger) With(kv ...interface{}) Logger     { return n }
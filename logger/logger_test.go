@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestStd(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewStd(log.New(buf, "", 0), LevelInfo)
+	l.Debug("调试")
+	l.Info("正在执行", "url", "http://a.com", "worker", 1)
+	l.With("spider", "gaodemap").Warn("代理被封禁", "proxy")
+	l.Error("失败")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		"[INFO] 正在执行 url=http://a.com worker=1",
+		"[WARN] 代理被封禁 spider=gaodemap proxy=<缺失>",
+		"[ERROR] 失败",
+	}, lines)
+}
+
+func TestSlog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	l := NewSlog(slog.New(handler))
+	l.Debug("调试")
+	l.With("spider", "baidumap").Info("正在执行", "worker", 2)
+	assert.Equal(t, "level=INFO msg=正在执行 spider=baidumap worker=2\n", buf.String())
+}
+
+func TestDefault(t *testing.T) {
+	old := Default()
+	defer SetDefault(old)
+	SetDefault(NewNop())
+	Default().With("a", 1).Error("丢弃")
+	assert.Equal(t, NewNop(), Default())
+}
@@ -0,0 +1,35 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// 适配log/slog
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func NewSlog(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+func (s *slogLogger) Debug(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelDebug, msg, kv...)
+}
+
+func (s *slogLogger) Info(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelInfo, msg, kv...)
+}
+
+func (s *slogLogger) Warn(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelWarn, msg, kv...)
+}
+
+func (s *slogLogger) Error(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelError, msg, kv...)
+}
+
+func (s *slogLogger) With(kv ...interface{}) Logger {
+	return &slogLogger{l: s.l.With(kv...)}
+}
@@ -0,0 +1,198 @@
+package digger
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/proxy"
+	"github.com/spencer404/go-digger/storage"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OnProcess耗时的分桶上限, 单位秒
+var processDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// 运行期间累计的指标, 其余指标在输出时从Queue, Filter与代理池读取
+type metrics struct {
+	processed uint64 // OnProcess执行次数
+	failed    uint64 // OnProcess返回错误的次数, 不含ErrSkip
+	duration  *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{duration: newHistogram(processDurationBuckets)}
+}
+
+// 记录一次OnProcess的耗时与结果
+func (m *metrics) observe(d time.Duration, err error) {
+	atomic.AddUint64(&m.processed, 1)
+	if err != nil && !errors.Is(err, ErrSkip) {
+		atomic.AddUint64(&m.failed, 1)
+	}
+	m.duration.observe(d.Seconds())
+}
+
+// 直方图, 对应Prometheus的histogram类型
+type histogram struct {
+	bounds []float64 // 各分桶的上限, 升序
+	counts []uint64  // 落入各分桶的数量, 非累计
+	sum    float64
+	count  uint64
+	lock   sync.Mutex
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	writeHeader(w, name, help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 队列中各状态的URL数量
+var metricsStates = []struct {
+	state storage.State
+	name  string
+}{
+	{storage.StateWaiting, "waiting"},
+	{storage.StateProcessing, "processing"},
+	{storage.StateFailed, "failed"},
+}
+
+// 以Prometheus文本格式输出全部指标, 读取Queue或Filter失败时返回错误
+func (r *Reactor) writeMetrics(w io.Writer) error {
+	// 队列长度
+	writeHeader(w, "digger_queue_length", "队列中各状态, 各优先级的URL数量", "gauge")
+	for _, s := range metricsStates {
+		lengths, err := r.Queue.Length(s.state)
+		if err != nil {
+			return errors.Wrapf(err, "获取%s队列长度失败", s.name)
+		}
+		priorities := make([]int, 0, len(lengths))
+		for p := range lengths {
+			priorities = append(priorities, int(p))
+		}
+		sort.Ints(priorities)
+		for _, p := range priorities {
+			fmt.Fprintf(w, "digger_queue_length{state=%q,priority=\"%d\"} %d\n", s.name, p, lengths[storage.Priority(p)])
+		}
+	}
+	// 处理结果
+	writeHeader(w, "digger_processed_total", "OnProcess执行次数", "counter")
+	fmt.Fprintf(w, "digger_processed_total %d\n", atomic.LoadUint64(&r.metrics.processed))
+	writeHeader(w, "digger_failed_total", "OnProcess返回错误的次数, 不含ErrSkip", "counter")
+	fmt.Fprintf(w, "digger_failed_total %d\n", atomic.LoadUint64(&r.metrics.failed))
+	r.metrics.duration.write(w, "digger_process_duration_seconds", "OnProcess耗时")
+	r.inFlightLock.Lock()
+	inFlight := len(r.inFlight)
+	r.inFlightLock.Unlock()
+	writeHeader(w, "digger_in_flight", "正在处理的URL数量", "gauge")
+	fmt.Fprintf(w, "digger_in_flight %d\n", inFlight)
+	writeHeader(w, "digger_concurrency", "当前并发数", "gauge")
+	fmt.Fprintf(w, "digger_concurrency %d\n", r.Concurrency())
+	paused := 0
+	if r.Paused() {
+		paused = 1
+	}
+	writeHeader(w, "digger_paused", "是否已暂停", "gauge")
+	fmt.Fprintf(w, "digger_paused %d\n", paused)
+	// 代理池
+	writeHeader(w, "digger_proxy_pool", "代理池各列表中的代理数量", "gauge")
+	for _, list := range r.proxyPoolSizes() {
+		fmt.Fprintf(w, "digger_proxy_pool{list=%q} %d\n", list.name, list.size)
+	}
+	fetchErrors := proxy.FetchErrors()
+	providers := make([]string, 0, len(fetchErrors))
+	for name := range fetchErrors {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
+	writeHeader(w, "digger_proxy_provider_errors_total", "请求代理接口失败的次数", "counter")
+	for _, name := range providers {
+		fmt.Fprintf(w, "digger_proxy_provider_errors_total{provider=%q} %d\n", name, fetchErrors[name])
+	}
+	// Filter
+	if r.filter != nil {
+		n, err := r.filter.Count()
+		if err != nil {
+			return errors.Wrap(err, "获取Filter数量失败")
+		}
+		writeHeader(w, "digger_filter_count", "Filter中已完成的URL数量", "gauge")
+		fmt.Fprintf(w, "digger_filter_count %d\n", n)
+	}
+	return nil
+}
+
+// 代理池中某个列表的长度
+type poolSize struct {
+	name string
+	size int
+}
+
+// 代理池各列表的长度
+func (r *Reactor) proxyPoolSizes() []poolSize {
+	r.putBackLock.Lock()
+	putBack := len(r.putBackList)
+	r.putBackLock.Unlock()
+	r.freezeLock.Lock()
+	freeze := 0
+	for _, item := range r.freezeList {
+		freeze += len(item.ps)
+	}
+	r.freezeLock.Unlock()
+	return []poolSize{
+		{"pool", r.pool.Len()},
+		{"putback", putBack},
+		{"freeze", freeze},
+		{"black", r.blackList.Count()},
+	}
+}
+
+// 以Prometheus文本格式提供指标, 可挂载到自定义的HTTP服务上
+func (r *Reactor) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf := &bytes.Buffer{}
+		if err := r.writeMetrics(buf); err != nil {
+			r.log.Error("输出指标失败", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = buf.WriteTo(w)
+	})
+}
@@ -0,0 +1,104 @@
+package digger
+
+import (
+	"bytes"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/storage"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	h.observe(0.05)
+	h.observe(0.1)
+	h.observe(0.5)
+	h.observe(3)
+	buf := &bytes.Buffer{}
+	h.write(buf, "x", "测试")
+	assert.Equal(t, `# HELP x 测试
+# TYPE x histogram
+x_bucket{le="0.1"} 2
+x_bucket{le="1"} 3
+x_bucket{le="+Inf"} 4
+x_sum 3.65
+x_count 4
+`, buf.String())
+}
+
+func TestReactor_MetricsHandler(t *testing.T) {
+	filter := newMemStore()
+	queue := storage.NewMemQueue(newMemStore(), time.Minute)
+	r, err := NewReactor(queue, newMemStore(), 2, NewReactorOpt().Retry(0).MetricsFilter(filter))
+	assert.Nil(t, err)
+	spider := &Spider{
+		Seeders: []string{"a", "b", "c", "d"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			switch item.URL {
+			case "c":
+				return ErrSkip
+			case "d":
+				return errors.New("失败")
+			}
+			return filter.Insert(item.URL)
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	rec := httptest.NewRecorder()
+	r.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	for _, line := range []string{
+		`digger_queue_length{state="failed",priority="0"} 1`,
+		"digger_processed_total 4",
+		"digger_failed_total 1",
+		`digger_process_duration_seconds_bucket{le="+Inf"} 4`,
+		"digger_process_duration_seconds_count 4",
+		"digger_in_flight 0",
+		"digger_concurrency 2",
+		`digger_proxy_pool{list="pool"} 0`,
+		"digger_filter_count 2",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestReactor_Metrics(t *testing.T) {
+	// 获取一个空闲端口
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	assert.Nil(t, ln.Close())
+	r := newTestReactor(t, 1, NewReactorOpt().Metrics(addr))
+	spider := &Spider{
+		Seeders: []string{"a"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			// 运行期间可访问指标
+			resp, err := http.Get("http://" + addr + "/metrics")
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(string(body), "digger_in_flight 1\n") {
+				return errors.Errorf("指标错误: %s", body)
+			}
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, uint64(0), r.metrics.failed)
+	// 结束后关闭
+	_, err = http.Get("http://" + addr + "/metrics")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,104 @@
+package digger
+
+import (
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/logger"
+	"github.com/spencer404/go-digger/storage"
+	"time"
+)
+
+// 中间件, 以钩子的形式注册到Reactor为每个URL创建的resty.Client上
+// 先执行ReactorOpt.Use添加的, 再执行Spider.Middlewares中的, 各自按添加顺序; 钩子均可为nil
+// BeforeRequest与AfterResponse返回错误时, 后续中间件不再执行, 错误由请求方法原样返回, 可使用ProxyBanned等决定URL与代理的去向
+type Middleware struct {
+	BeforeRequest func(hc *HookContext, req *resty.Request) error      // 发送请求前调用
+	AfterResponse func(hc *HookContext, resp *resty.Response) error    // 收到响应后调用, 包括非2xx的响应
+	OnError       func(hc *HookContext, req *resty.Request, err error) // 请求出错或钩子返回错误时调用
+}
+
+// 钩子执行时的上下文
+type HookContext struct {
+	Item   *storage.QueueItem
+	Proxy  string        // 代理地址, 已隐藏密码, 未使用代理时为空
+	Logger logger.Logger // 附带Goroutine编号的日志
+}
+
+// 将中间件注册到c上
+func useMiddlewares(c *resty.Client, hc *HookContext, ms []Middleware) {
+	for _, m := range ms {
+		m := m
+		if m.BeforeRequest != nil {
+			c.OnBeforeRequest(func(c *resty.Client, req *resty.Request) error {
+				return m.BeforeRequest(hc, req)
+			})
+		}
+		if m.AfterResponse != nil {
+			c.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+				return m.AfterResponse(hc, resp)
+			})
+		}
+		if m.OnError != nil {
+			c.OnError(func(req *resty.Request, err error) {
+				m.OnError(hc, req, err)
+			})
+		}
+	}
+}
+
+// 为未设置的请求头设置默认值
+func DefaultHeaders(headers map[string]string) Middleware {
+	return Middleware{
+		BeforeRequest: func(hc *HookContext, req *resty.Request) error {
+			for k, v := range headers {
+				if req.Header.Get(k) == "" {
+					req.Header.Set(k, v)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// 状态码不在codes中时返回错误, codes为空时仅接受200
+func ExpectStatus(codes ...int) Middleware {
+	if len(codes) == 0 {
+		codes = []int{200}
+	}
+	return Middleware{
+		AfterResponse: func(hc *HookContext, resp *resty.Response) error {
+			for _, code := range codes {
+				if resp.StatusCode() == code {
+					return nil
+				}
+			}
+			return errors.Errorf("接口返回异常状态码: %d", resp.StatusCode())
+		},
+	}
+}
+
+// banned返回true时视为代理被封禁, 返回ProxyBanned(d, ...)
+func DetectBan(d time.Duration, banned func(resp *resty.Response) bool) Middleware {
+	return Middleware{
+		AfterResponse: func(hc *HookContext, resp *resty.Response) error {
+			if banned(resp) {
+				return ProxyBanned(d, errors.Errorf("请求被限制, 状态码: %d", resp.StatusCode()))
+			}
+			return nil
+		},
+	}
+}
+
+// 以Debug级别记录每个响应, 以Warn级别记录请求错误
+func LogResponses() Middleware {
+	return Middleware{
+		AfterResponse: func(hc *HookContext, resp *resty.Response) error {
+			hc.Logger.Debug("已收到响应", "url", resp.Request.URL, "proxy", hc.Proxy,
+				"status", resp.StatusCode(), "size", len(resp.Body()), "duration", resp.Time())
+			return nil
+		},
+		OnError: func(hc *HookContext, req *resty.Request, err error) {
+			hc.Logger.Warn("请求失败", "url", req.URL, "proxy", hc.Proxy, "error", err)
+		},
+	}
+}
@@ -0,0 +1,90 @@
+package digger
+
+import (
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/logger"
+	"github.com/spencer404/go-digger/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMiddlewareServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/500":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/ban":
+			_, _ = w.Write([]byte("banned"))
+		default:
+			_, _ = w.Write([]byte(req.Header.Get("X-A") + "," + req.Header.Get("X-B")))
+		}
+	}))
+}
+
+func TestMiddlewares(t *testing.T) {
+	server := newMiddlewareServer()
+	defer server.Close()
+	var errs []error
+	c := resty.New()
+	useMiddlewares(c, &HookContext{Logger: logger.NewNop()}, []Middleware{
+		DefaultHeaders(map[string]string{"X-A": "1", "X-B": "2"}),
+		ExpectStatus(),
+		DetectBan(time.Minute, func(resp *resty.Response) bool {
+			return resp.String() == "banned"
+		}),
+		LogResponses(),
+		{OnError: func(hc *HookContext, req *resty.Request, err error) {
+			errs = append(errs, err)
+		}},
+	})
+	// 已设置的请求头不被覆盖
+	resp, err := c.R().SetHeader("X-B", "3").Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "1,3", resp.String())
+	assert.Len(t, errs, 0)
+	// 状态码异常
+	_, err = c.R().Get(server.URL + "/500")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "500")
+	assert.Len(t, errs, 1)
+	// 被封禁, 后续中间件不再执行
+	_, err = c.R().Get(server.URL + "/ban")
+	var banned *ProxyBannedError
+	assert.True(t, errors.As(err, &banned), err)
+	assert.Equal(t, time.Minute, banned.Duration)
+	assert.Len(t, errs, 2)
+}
+
+func TestReactor_Run_Middlewares(t *testing.T) {
+	server := newMiddlewareServer()
+	defer server.Close()
+	var lock sync.Mutex
+	calls := make([]string, 0)
+	record := func(name string) Middleware {
+		return Middleware{
+			BeforeRequest: func(hc *HookContext, req *resty.Request) error {
+				lock.Lock()
+				calls = append(calls, name+":"+hc.Item.URL)
+				lock.Unlock()
+				return nil
+			},
+		}
+	}
+	r := newTestReactor(t, 1, NewReactorOpt().Use(record("reactor")))
+	url := server.URL + "/a"
+	spider := &Spider{
+		Seeders:     []string{url},
+		Middlewares: []Middleware{record("spider")},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			_, err := client.R().Get(item.URL)
+			return err
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, []string{"reactor:" + url, "spider:" + url}, calls)
+}
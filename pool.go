@@ -1,6 +1,11 @@
 package digger
 
-import "time"
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
 
 type flag int
 
@@ -15,9 +20,15 @@ const (
 // 代理
 type Proxy struct {
 	URL         string
-	Index       int       // 序号，Proxy会被添加parallels次到poolCh中，用于区分
+	Index       int       // 序号，Proxy会被添加parallels次到代理池中，用于区分
 	CreateTime  time.Time // 创建时间
-	ExpiredTime time.Time // 过期时间
+	ExpiredTime time.Time // 过期时间, 为零值时不过期
+	CheckedTime time.Time // 最近一次通过校验的时间
+}
+
+// 在now时是否已过期
+func (p *Proxy) expired(now time.Time) bool {
+	return !p.ExpiredTime.IsZero() && !p.ExpiredTime.After(now)
 }
 
 type ProxyHelper struct {
@@ -49,3 +60,284 @@ type freezeItem struct {
 }
 
 type freezeList map[string]*freezeItem
+
+// 代理的使用统计, 按代理地址汇总
+type ProxyStats struct {
+	Success  int           // 成功次数
+	Failure  int           // 失败次数
+	Bans     int           // 被禁用或冻结的次数
+	LastBan  time.Time     // 最近一次被禁用或冻结的时间
+	Latency  time.Duration // 处理耗时的滑动平均
+	LastUsed time.Time     // 最近一次被选取的时间
+}
+
+// 近期被封禁的代理在该时长内降低得分
+const proxyBanMemory = time.Hour
+
+// 成功率, 未使用过的代理为0.5
+func (s ProxyStats) SuccessRate() float64 {
+	return float64(s.Success+1) / float64(s.Success+s.Failure+2)
+}
+
+// 综合得分, 成功率越高, 耗时越短, 近期被封禁次数越少, 得分越高
+func (s ProxyStats) Score() float64 {
+	score := s.SuccessRate() / (1 + s.Latency.Seconds())
+	if !s.LastBan.IsZero() && time.Since(s.LastBan) < proxyBanMemory {
+		score /= float64(1 + s.Bans)
+	}
+	return score
+}
+
+// 候选代理
+type ProxyCandidate struct {
+	*Proxy
+	Stats ProxyStats
+}
+
+// 代理选取策略, 从空闲代理中选取一个, 返回其在candidates中的下标
+// candidates不为空, 按放入代理池的先后排列
+type ProxyStrategy func(candidates []ProxyCandidate) int
+
+// 选取最久未被使用的代理, 默认策略
+func LeastRecentlyUsed() ProxyStrategy {
+	return func(candidates []ProxyCandidate) int {
+		best := 0
+		for i, c := range candidates {
+			if c.Stats.LastUsed.Before(candidates[best].Stats.LastUsed) {
+				best = i
+			}
+		}
+		return best
+	}
+}
+
+// 选取得分最高的代理
+func BestScore() ProxyStrategy {
+	return func(candidates []ProxyCandidate) int {
+		best, bestScore := 0, candidates[0].Stats.Score()
+		for i, c := range candidates[1:] {
+			if score := c.Stats.Score(); score > bestScore {
+				best, bestScore = i+1, score
+			}
+		}
+		return best
+	}
+}
+
+// 按得分加权随机选取代理, 得分高的代理被选中的概率更大
+func WeightedRandom() ProxyStrategy {
+	return func(candidates []ProxyCandidate) int {
+		scores := make([]float64, len(candidates))
+		total := 0.0
+		for i, c := range candidates {
+			scores[i] = c.Stats.Score()
+			total += scores[i]
+		}
+		n := rand.Float64() * total
+		for i, score := range scores {
+			if n < score {
+				return i
+			}
+			n -= score
+		}
+		return len(candidates) - 1
+	}
+}
+
+// 自动淘汰表现不佳的代理, 被淘汰的代理加入黑名单, 其统计被清空
+type ProxyEviction struct {
+	MinRequests    int           // 请求次数达到该值后才检查成功率, 默认20
+	MinSuccessRate float64       // 成功率低于该值时淘汰, 为0时不检查
+	MaxBans        int           // 被禁用或冻结的次数达到该值时淘汰, 为0时不检查
+	Duration       time.Duration // 加入黑名单的时长, 默认1小时
+}
+
+func (e ProxyEviction) withDefaults() ProxyEviction {
+	if e.MinRequests < 1 {
+		e.MinRequests = 20
+	}
+	if e.Duration <= 0 {
+		e.Duration = time.Hour
+	}
+	return e
+}
+
+// 是否应淘汰
+func (e *ProxyEviction) evict(s ProxyStats) bool {
+	if e.MaxBans > 0 && s.Bans >= e.MaxBans {
+		return true
+	}
+	total := s.Success + s.Failure
+	return e.MinSuccessRate > 0 && total >= e.MinRequests && float64(s.Success)/float64(total) < e.MinSuccessRate
+}
+
+// 空闲代理池, 按ProxyStrategy选取代理, 并记录各代理的使用统计
+type proxyPool struct {
+	strategy ProxyStrategy
+	idle     []*Proxy
+	stats    map[string]*ProxyStats // 代理地址 -> 统计
+	slots    chan struct{}          // 限制空闲代理的数量, 满时push阻塞
+	ready    chan struct{}          // 可能有空闲代理时可读
+	lock     sync.Mutex
+}
+
+func newProxyPool(size int, strategy ProxyStrategy) *proxyPool {
+	return &proxyPool{
+		strategy: strategy,
+		idle:     make([]*Proxy, 0, size),
+		stats:    make(map[string]*ProxyStats),
+		slots:    make(chan struct{}, size),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// 放入空闲代理, 池满时阻塞, ctx被取消时返回false
+func (p *proxyPool) push(ctx context.Context, proxy *Proxy) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	p.lock.Lock()
+	p.idle = append(p.idle, proxy)
+	p.lock.Unlock()
+	p.signal()
+	return true
+}
+
+func (p *proxyPool) signal() {
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+// 按策略取出一个空闲代理, 超过timeout仍没有空闲代理时返回nil
+func (p *proxyPool) take(timeout time.Duration) *Proxy {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if proxy := p.pick(); proxy != nil {
+			return proxy
+		}
+		select {
+		case <-p.ready:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+func (p *proxyPool) pick() *Proxy {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	candidates := make([]ProxyCandidate, len(p.idle))
+	for i, proxy := range p.idle {
+		candidates[i] = ProxyCandidate{Proxy: proxy}
+		if s, exist := p.stats[proxy.URL]; exist {
+			candidates[i].Stats = *s
+		}
+	}
+	i := p.strategy(candidates)
+	if i < 0 || i >= len(p.idle) {
+		i = 0
+	}
+	proxy := p.idle[i]
+	p.idle = append(p.idle[:i], p.idle[i+1:]...)
+	<-p.slots
+	p.statsOf(proxy.URL).LastUsed = time.Now()
+	// 唤醒其他等待中的调用者
+	if len(p.idle) > 0 {
+		p.signal()
+	}
+	return proxy
+}
+
+// 取出满足条件的空闲代理
+func (p *proxyPool) removeIf(fn func(*Proxy) bool) []*Proxy {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	removed := make([]*Proxy, 0)
+	idle := p.idle[:0]
+	for _, proxy := range p.idle {
+		if fn(proxy) {
+			removed = append(removed, proxy)
+			<-p.slots
+		} else {
+			idle = append(idle, proxy)
+		}
+	}
+	p.idle = idle
+	return removed
+}
+
+// 取出全部空闲代理
+func (p *proxyPool) drain() []*Proxy {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ps := p.idle
+	p.idle = make([]*Proxy, 0, cap(p.slots))
+	for range ps {
+		<-p.slots
+	}
+	return ps
+}
+
+// 空闲代理的数量
+func (p *proxyPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.idle)
+}
+
+func (p *proxyPool) statsOf(url string) *ProxyStats {
+	s, exist := p.stats[url]
+	if !exist {
+		s = &ProxyStats{}
+		p.stats[url] = s
+	}
+	return s
+}
+
+// 记录一次使用的结果, 返回记录后的统计
+func (p *proxyPool) record(url string, success bool, latency time.Duration, banned bool) ProxyStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s := p.statsOf(url)
+	if success {
+		s.Success++
+	} else {
+		s.Failure++
+	}
+	if s.Latency == 0 {
+		s.Latency = latency
+	} else {
+		s.Latency = (s.Latency*4 + latency) / 5
+	}
+	if banned {
+		s.Bans++
+		s.LastBan = time.Now()
+	}
+	return *s
+}
+
+// 清空代理的统计
+func (p *proxyPool) forget(url string) {
+	p.lock.Lock()
+	delete(p.stats, url)
+	p.lock.Unlock()
+}
+
+// 全部代理的统计
+func (p *proxyPool) Stats() map[string]ProxyStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := make(map[string]ProxyStats, len(p.stats))
+	for url, s := range p.stats {
+		result[url] = *s
+	}
+	return result
+}
@@ -0,0 +1,278 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	mapset "github.com/deckarep/golang-set"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/proxy"
+	"github.com/spencer404/go-digger/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProxyStats_Score(t *testing.T) {
+	assert.Equal(t, 0.5, ProxyStats{}.Score())
+	good := ProxyStats{Success: 9, Failure: 1, Latency: time.Millisecond * 100}
+	slow := ProxyStats{Success: 9, Failure: 1, Latency: time.Second * 2}
+	bad := ProxyStats{Success: 1, Failure: 9, Latency: time.Millisecond * 100}
+	assert.Greater(t, good.Score(), slow.Score())
+	assert.Greater(t, good.Score(), bad.Score())
+	// 近期被封禁时降低得分
+	banned := good
+	banned.Bans, banned.LastBan = 1, time.Now()
+	assert.Greater(t, good.Score(), banned.Score())
+	banned.LastBan = time.Now().Add(-proxyBanMemory)
+	assert.Equal(t, good.Score(), banned.Score())
+}
+
+func TestProxyStrategy(t *testing.T) {
+	now := time.Now()
+	candidates := []ProxyCandidate{
+		{Proxy: &Proxy{URL: "a"}, Stats: ProxyStats{Success: 1, Failure: 9, LastUsed: now}},
+		{Proxy: &Proxy{URL: "b"}, Stats: ProxyStats{Success: 9, Failure: 1, LastUsed: now}},
+		{Proxy: &Proxy{URL: "c"}, Stats: ProxyStats{LastUsed: now.Add(-time.Minute)}},
+	}
+	assert.Equal(t, 2, LeastRecentlyUsed()(candidates))
+	assert.Equal(t, 1, BestScore()(candidates))
+	counts := make([]int, len(candidates))
+	strategy := WeightedRandom()
+	for i := 0; i < 1000; i++ {
+		counts[strategy(candidates)]++
+	}
+	assert.Greater(t, counts[1], counts[2])
+	assert.Greater(t, counts[2], counts[0])
+}
+
+func TestProxyPool(t *testing.T) {
+	ctx := context.Background()
+	pool := newProxyPool(2, LeastRecentlyUsed())
+	p1, p2 := &Proxy{URL: "a"}, &Proxy{URL: "b"}
+	assert.True(t, pool.push(ctx, p1))
+	assert.True(t, pool.push(ctx, p2))
+	// 池满时阻塞
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, pool.push(cancelled, &Proxy{URL: "c"}))
+	assert.Equal(t, 2, pool.Len())
+	// 未使用过的按放入顺序选取, 之后选取最久未使用的
+	assert.True(t, pool.take(time.Second) == p1)
+	assert.True(t, pool.push(ctx, p1))
+	assert.True(t, pool.take(time.Second) == p2)
+	assert.True(t, pool.take(time.Second) == p1)
+	assert.Nil(t, pool.take(time.Millisecond*10))
+	// 等待中的调用者被唤醒
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		pool.push(ctx, p2)
+	}()
+	assert.True(t, pool.take(time.Second) == p2)
+	// 统计
+	pool.record("a", true, time.Second, false)
+	s := pool.record("a", false, time.Second*6, true)
+	assert.Equal(t, 1, s.Success)
+	assert.Equal(t, 1, s.Failure)
+	assert.Equal(t, 1, s.Bans)
+	assert.Equal(t, time.Second*2, s.Latency)
+	pool.forget("a")
+	_, exist := pool.Stats()["a"]
+	assert.False(t, exist)
+	assert.Contains(t, pool.Stats(), "b")
+	// 取出全部空闲代理后可继续放入
+	assert.True(t, pool.push(ctx, p1))
+	assert.True(t, pool.push(ctx, p2))
+	assert.Len(t, pool.drain(), 2)
+	assert.True(t, pool.push(ctx, p1))
+	// 策略返回无效下标时选取第一个
+	pool = newProxyPool(1, func(candidates []ProxyCandidate) int { return -1 })
+	assert.True(t, pool.push(ctx, p1))
+	assert.True(t, pool.take(time.Second) == p1)
+}
+
+func TestProxyEviction(t *testing.T) {
+	e := ProxyEviction{MinSuccessRate: 0.5, MaxBans: 3}.withDefaults()
+	assert.False(t, e.evict(ProxyStats{Failure: 19}))
+	assert.True(t, e.evict(ProxyStats{Success: 9, Failure: 11}))
+	assert.False(t, e.evict(ProxyStats{Success: 10, Failure: 10}))
+	assert.True(t, e.evict(ProxyStats{Success: 10, Bans: 3}))
+	assert.Equal(t, time.Hour, e.Duration)
+}
+
+func TestReactor_Run_ProxyEviction(t *testing.T) {
+	url := "http://127.0.0.1:1"
+	provider := make(chan proxy.Item, 1)
+	provider <- proxy.Item{URL: url, EnableFilter: true}
+	r := newTestReactor(t, 1, NewReactorOpt().
+		ProxyProviders(provider).
+		ProxyStrategy(BestScore()).
+		ProxyEviction(ProxyEviction{MaxBans: 1, Duration: time.Minute}))
+	spider := &Spider{
+		Seeders: []string{"http://a.com"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			proxy.Freeze(time.Hour)
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	// 被拉黑而非冻结, 统计被清空
+	_, exist := r.blackList.Get(url)
+	assert.True(t, exist)
+	assert.Len(t, r.freezeList, 0)
+	assert.Len(t, r.ProxyStats(), 0)
+}
+
+func TestReactor_Run_ProxyForbidden(t *testing.T) {
+	// 被禁用的代理不再保留统计
+	url := "http://127.0.0.1:1"
+	provider := make(chan proxy.Item, 1)
+	provider <- proxy.Item{URL: url, EnableFilter: true}
+	r := newTestReactor(t, 1, NewReactorOpt().ProxyProviders(provider))
+	spider := &Spider{
+		Seeders: []string{"http://a.com"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			proxy.Forbidden(time.Hour)
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	_, exist := r.blackList.Get(url)
+	assert.True(t, exist)
+	assert.NotContains(t, r.ProxyStats(), url)
+}
+
+func TestReactor_dropExpired(t *testing.T) {
+	r := newTestReactor(t, 1, NewReactorOpt())
+	r.pool = newProxyPool(4, LeastRecentlyUsed())
+	r.poolFilter = mapset.NewSet()
+	r.freezeList = make(freezeList)
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+	idle := []*Proxy{{URL: "a", ExpiredTime: past}, {URL: "b"}}
+	for _, p := range idle {
+		r.poolFilter.Add(fmt.Sprintf("%s:%d", p.URL, p.Index))
+		assert.True(t, r.pool.push(context.Background(), p))
+	}
+	r.putBackList = []*Proxy{{URL: "c", ExpiredTime: past}, {URL: "d", ExpiredTime: future}}
+	r.freezeList["e"] = &freezeItem{t: future, ps: []*Proxy{{URL: "e", ExpiredTime: past}}}
+	r.freezeList["f"] = &freezeItem{t: future, ps: []*Proxy{{URL: "f", Index: 0, ExpiredTime: past}, {URL: "f", Index: 1}}}
+	r.dropExpired()
+	assert.Equal(t, []*Proxy{idle[1]}, r.pool.drain())
+	assert.Len(t, r.putBackList, 1)
+	assert.Equal(t, "d", r.putBackList[0].URL)
+	assert.NotContains(t, r.freezeList, "e")
+	assert.Len(t, r.freezeList["f"].ps, 1)
+	assert.Equal(t, 1, r.freezeList["f"].ps[0].Index)
+	assert.False(t, r.poolFilter.Contains("a:0"))
+	assert.True(t, r.poolFilter.Contains("b:0"))
+}
+
+func TestReactor_Run_ProxyExpired(t *testing.T) {
+	// 已过期的代理不被使用
+	provider := make(chan proxy.Item, 2)
+	provider <- proxy.Item{URL: "http://127.0.0.1:1", ExpiredTime: time.Now().Add(-time.Second)}
+	provider <- proxy.NewItem("http://127.0.0.1:2", false, time.Hour)
+	used := make([]string, 0)
+	r := newTestReactor(t, 1, NewReactorOpt().ProxyProviders(provider).Use(Middleware{
+		BeforeRequest: func(hc *HookContext, req *resty.Request) error {
+			used = append(used, hc.Proxy)
+			return ErrSkip
+		},
+	}))
+	spider := &Spider{
+		Seeders: []string{"http://a.com"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			_, err := client.R().Get(item.URL)
+			return err
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, []string{"http://127.0.0.1:2"}, used)
+}
+
+func TestReactor_retireProxy(t *testing.T) {
+	r := newTestReactor(t, 1, NewReactorOpt().ProxyParallels(2))
+	r.pool = newProxyPool(4, LeastRecentlyUsed())
+	r.poolFilter = mapset.NewSet()
+	r.retired = mapset.NewSet()
+	r.freezeList = make(freezeList)
+	for _, url := range []string{"a", "b"} {
+		r.poolFilter.Add(url + ":0")
+		r.poolFilter.Add(url + ":1")
+		assert.True(t, r.pool.push(context.Background(), &Proxy{URL: url}))
+	}
+	r.putBackList = []*Proxy{{URL: "a", Index: 1}}
+	r.retireProxy("a")
+	assert.Equal(t, 1, r.pool.Len())
+	assert.Len(t, r.putBackList, 0)
+	assert.False(t, r.poolFilter.Contains("a:0"))
+	assert.False(t, r.poolFilter.Contains("a:1"))
+	assert.True(t, r.poolFilter.Contains("b:0"))
+	assert.True(t, r.retired.Contains("a"))
+}
+
+func TestReactor_Run_StaticProvider(t *testing.T) {
+	// Provider不再提供新代理时, 归还的代理仍可被再次使用
+	used := make([]string, 0)
+	r := newTestReactor(t, 1, NewReactorOpt().ProxyProviders(proxy.NewStaticProvider("127.0.0.1:1")).Use(Middleware{
+		BeforeRequest: func(hc *HookContext, req *resty.Request) error {
+			used = append(used, hc.Proxy)
+			return ErrSkip
+		},
+	}))
+	spider := &Spider{
+		Seeders: []string{"http://a.com/1", "http://a.com/2", "http://a.com/3"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			_, err := client.R().Get(item.URL)
+			return err
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, []string{"http://127.0.0.1:1", "http://127.0.0.1:1", "http://127.0.0.1:1"}, used)
+}
+
+func TestReactor_Run_StaticProvider_Deleted(t *testing.T) {
+	// OnProcess失败时代理被删除, 固定列表重新提供后仍可被再次使用
+	used := make([]string, 0)
+	provider := proxy.NewStaticProviderWithInterval(time.Millisecond*10, "127.0.0.1:1")
+	r := newTestReactor(t, 1, NewReactorOpt().Retry(0).ProxyProviders(provider).Use(Middleware{
+		BeforeRequest: func(hc *HookContext, req *resty.Request) error {
+			used = append(used, hc.Proxy)
+			return errors.New("失败")
+		},
+	}))
+	spider := &Spider{
+		Seeders: []string{"http://a.com/1", "http://a.com/2", "http://a.com/3"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			_, err := client.R().Get(item.URL)
+			return err
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	assert.Nil(t, r.RunContext(ctx, spider))
+	assert.Equal(t, []string{"http://127.0.0.1:1", "http://127.0.0.1:1", "http://127.0.0.1:1"}, used)
+}
+
+func TestReactor_Run_RetiredProxy(t *testing.T) {
+	provider := make(chan proxy.Item, 3)
+	provider <- proxy.Item{URL: "http://127.0.0.1:1", EnableFilter: true}
+	provider <- proxy.Item{URL: "http://127.0.0.1:1", Retire: true}
+	provider <- proxy.Item{URL: "http://127.0.0.1:2", EnableFilter: true}
+	used := make([]string, 0)
+	r := newTestReactor(t, 1, NewReactorOpt().ProxyProviders(provider).Use(Middleware{
+		BeforeRequest: func(hc *HookContext, req *resty.Request) error {
+			used = append(used, hc.Proxy)
+			return ErrSkip
+		},
+	}))
+	spider := &Spider{
+		Seeders: []string{"http://a.com/1", "http://a.com/2"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			_, err := client.R().Get(item.URL)
+			return err
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, []string{"http://127.0.0.1:2", "http://127.0.0.1:2"}, used)
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/logger"
+	"net"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON代理接口的参数
+// 字段路径以"."分隔, 数组下标为数字, 如"data.list"或"data.0.ip"
+type JSONAPIOpt struct {
+	URL             string
+	Interval        time.Duration // 请求接口的间隔
+	EnableFilter    bool
+	List            string        // 代理列表的路径, 为空时响应本身即为列表
+	IP              string        // IP的路径, 必填; Port为空时其值应为"ip:port"
+	Port            string        // 端口的路径
+	Protocol        string        // 协议的路径, 为空或值为空时使用DefaultProtocol
+	DefaultProtocol string        // 默认协议, 默认为http
+	Username        string        // 用户名的路径, 为空时不认证
+	Password        string        // 密码的路径
+	ExpireTime      string        // 过期时间的路径, 值可为Unix时间戳(秒或毫秒)或符合TimeLayout的字符串
+	TimeLayout      string        // 过期时间的格式, 按本地时区解析, 默认为"2006-01-02 15:04:05"
+	TTL             string        // 剩余有效秒数的路径, 设置ExpireTime时忽略
+	DefaultTTL      time.Duration // 未获得过期时间时的有效期, 为0时不过期
+	Code            string        // 状态码的路径, 为空时不检查
+	SuccessCode     string        // 成功时状态码的值, 如"0"或"200"
+	Message         string        // 错误信息的路径, 用于日志
+}
+
+// 按路径查找JSON中的值
+func lookup(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			value, exist := node[key]
+			if !exist {
+				return nil, false
+			}
+			v = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// 按路径查找JSON中的字符串或数字, 不存在或为null时返回空字符串
+func lookupString(v interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	value, exist := lookup(v, path)
+	if !exist || value == nil {
+		return ""
+	}
+	switch value := value.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case json.Number:
+		return value.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// 解析过期时间, Unix时间戳大于1e12时视为毫秒
+func parseExpireTime(s string, layout string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("过期时间格式错误: %q", s)
+	}
+	return t, nil
+}
+
+func (o JSONAPIOpt) withDefaults() JSONAPIOpt {
+	if o.DefaultProtocol == "" {
+		o.DefaultProtocol = "http"
+	}
+	if o.TimeLayout == "" {
+		o.TimeLayout = "2006-01-02 15:04:05"
+	}
+	return o
+}
+
+// 解析接口的响应, 跳过格式错误的代理, 并返回遇到的第一个错误
+func (o *JSONAPIOpt) parse(body []byte) ([]Item, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "代理接口返回的不是JSON")
+	}
+	if o.Code != "" {
+		if code := lookupString(doc, o.Code); code != o.SuccessCode {
+			return nil, errors.Errorf("代理接口返回错误: code=%s, message=%s", code, lookupString(doc, o.Message))
+		}
+	}
+	value, exist := lookup(doc, o.List)
+	if !exist {
+		return nil, errors.Errorf("代理接口返回的数据中没有%q", o.List)
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("代理接口返回的%q不是列表", o.List)
+	}
+	items := make([]Item, 0, len(list))
+	var firstErr error
+	for _, entry := range list {
+		item, err := o.parseItem(entry)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, firstErr
+}
+
+// 解析单个代理
+func (o *JSONAPIOpt) parseItem(entry interface{}) (Item, error) {
+	host := lookupString(entry, o.IP)
+	if host == "" {
+		return Item{}, errors.Errorf("代理缺少IP: %v", entry)
+	}
+	if port := lookupString(entry, o.Port); port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if _, _, err := net.SplitHostPort(host); err != nil {
+		return Item{}, errors.Errorf("代理缺少端口: %v", entry)
+	}
+	protocol := strings.ToLower(lookupString(entry, o.Protocol))
+	if protocol == "" {
+		protocol = o.DefaultProtocol
+	}
+	u := &neturl.URL{Scheme: protocol, Host: host}
+	if username := lookupString(entry, o.Username); username != "" {
+		u.User = neturl.UserPassword(username, lookupString(entry, o.Password))
+	}
+	item := NewItem(u.String(), o.EnableFilter, o.DefaultTTL)
+	if s := lookupString(entry, o.ExpireTime); s != "" {
+		t, err := parseExpireTime(s, o.TimeLayout)
+		if err != nil {
+			return Item{}, err
+		}
+		item.ExpiredTime = t
+	} else if s := lookupString(entry, o.TTL); s != "" {
+		ttl, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Item{}, errors.Errorf("剩余有效期格式错误: %q", s)
+		}
+		item.ExpiredTime = time.Now().Add(time.Duration(ttl * float64(time.Second)))
+	}
+	return item, nil
+}
+
+// 通用JSON代理接口
+// 按JSONAPIOpt中的路径提取各字段, 生成带认证信息与过期时间的代理
+func NewJSONAPIProvider(opt JSONAPIOpt) Provider {
+	opt = opt.withDefaults()
+	c := resty.New().R()
+	ch := make(chan Item, 0)
+	name := opt.URL
+	if u, err := neturl.Parse(opt.URL); err == nil {
+		name = u.Host
+	}
+	go func() {
+		for ; ; time.Sleep(opt.Interval) {
+			log := logger.Default()
+			log.Debug("正在请求代理接口")
+			resp, err := c.Get(opt.URL)
+			if err != nil {
+				log.Warn("请求代理接口失败", "error", err)
+				addFetchError(name)
+				continue
+			}
+			if resp.StatusCode() != 200 {
+				log.Warn("代理接口返回异常状态码", "status", resp.StatusCode(), "body", resp.String())
+				addFetchError(name)
+				continue
+			}
+			items, err := opt.parse(resp.Body())
+			if err != nil {
+				log.Warn("代理接口返回异常数据", "error", err, "body", resp.String())
+				addFetchError(name)
+			}
+			for _, item := range items {
+				ch <- item
+				log.Debug("从接口获得代理", "proxy", Redact(item.URL), "expired", item.ExpiredTime)
+			}
+		}
+	}()
+	return ch
+}
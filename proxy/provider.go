@@ -3,7 +3,8 @@ package proxy
 import (
 	"fmt"
 	"github.com/go-resty/resty/v2"
-	"log"
+	"github.com/spencer404/go-digger/logger"
+	neturl "net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -18,21 +19,33 @@ func splitAddress(s string) []string {
 // 通用代理
 // 兼容返回格式为"ip:port"，并以空格、换行符分隔的API接口
 func NewSimpleAPIProvider(url string, protocol string, interval time.Duration, enableFilter bool) Provider {
+	return NewSimpleAPIProviderWithTTL(url, protocol, interval, enableFilter, 0)
+}
+
+// 同NewSimpleAPIProvider, 获得的代理在ttl后过期, 用于有效期较短的付费代理
+func NewSimpleAPIProviderWithTTL(url string, protocol string, interval time.Duration, enableFilter bool, ttl time.Duration) Provider {
 	c := resty.New().R()
 	ch := make(chan Item, 0)
 	reIPPort := regexp.MustCompile(
 		`^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5]):[0-9]+$`,
 	)
+	name := url
+	if u, err := neturl.Parse(url); err == nil {
+		name = u.Host
+	}
 	go func() {
 		for ; ; time.Sleep(interval) {
-			log.Printf("正在请求代理接口")
+			log := logger.Default()
+			log.Debug("正在请求代理接口")
 			resp, err := c.Get(url)
 			if err != nil {
-				log.Printf("请求代理接口失败: %s", err)
+				log.Warn("请求代理接口失败", "error", err)
+				addFetchError(name)
 				continue
 			}
 			if resp.StatusCode() != 200 {
-				log.Printf("代理接口返回异常状态码: %d, %s", resp.StatusCode(), resp.String())
+				log.Warn("代理接口返回异常状态码", "status", resp.StatusCode(), "body", resp.String())
+				addFetchError(name)
 				continue
 			}
 			addresses := splitAddress(resp.String())
@@ -43,13 +56,14 @@ func NewSimpleAPIProvider(url string, protocol string, interval time.Duration, e
 				address = strings.TrimSpace(address)
 				if reIPPort.MatchString(addresses[0]) {
 					s := protocol + "://" + address
-					ch <- Item{s, enableFilter}
-					log.Printf("从接口获得代理: %s", s)
+					ch <- NewItem(s, enableFilter, ttl)
+					log.Debug("从接口获得代理", "proxy", Redact(s))
 				} else if i == 0 {
-					log.Printf("代理接口返回异常数据: %s", resp.String())
+					log.Warn("代理接口返回异常数据", "body", resp.String())
+					addFetchError(name)
 					break
 				} else {
-					log.Printf("代理格式错误: %s", address)
+					log.Warn("代理格式错误", "address", address)
 				}
 			}
 		}
@@ -70,7 +84,7 @@ func NewMoguHTTPTunnelProvider(username, password string) Provider {
 	const server = "secondtransfer.moguproxy.com:9001"
 	go func() {
 		for {
-			ch <- Item{fmt.Sprintf("http://%s:%s@%s", username, password, server), false}
+			ch <- Item{URL: fmt.Sprintf("http://%s:%s@%s", username, password, server)}
 		}
 	}()
 	return ch
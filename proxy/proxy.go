@@ -1,8 +1,58 @@
 package proxy
 
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
 type Item struct {
 	URL          string
 	EnableFilter bool
+	ExpiredTime  time.Time // 过期时间, 为零值时不过期
+	Retire       bool      // 为true时从代理池中移除该代理, 而非添加
+}
+
+// 有效期为ttl的代理, ttl不大于0时不过期
+func NewItem(url string, enableFilter bool, ttl time.Duration) Item {
+	item := Item{URL: url, EnableFilter: enableFilter}
+	if ttl > 0 {
+		item.ExpiredTime = time.Now().Add(ttl)
+	}
+	return item
+}
+
+// 隐藏代理地址中的密码, 用于日志与管理接口
+func Redact(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
 }
 
 type Provider <-chan Item
+
+// 各代理接口请求失败的次数
+var fetchErrors = struct {
+	m    map[string]uint64
+	lock sync.Mutex
+}{m: make(map[string]uint64)}
+
+// 记录一次请求代理接口失败, provider为接口的主机名
+func addFetchError(provider string) {
+	fetchErrors.lock.Lock()
+	fetchErrors.m[provider]++
+	fetchErrors.lock.Unlock()
+}
+
+// 各代理接口请求失败的次数, 以接口的主机名区分
+func FetchErrors() map[string]uint64 {
+	fetchErrors.lock.Lock()
+	defer fetchErrors.lock.Unlock()
+	m := make(map[string]uint64, len(fetchErrors.m))
+	for k, v := range fetchErrors.m {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	for s, expected := range map[string]string{
+		"1.2.3.4:80":                    "http://1.2.3.4:80",
+		"socks5://u:p@1.2.3.4:1080":     "socks5://u:p@1.2.3.4:1080",
+		"https://proxy.example.com:443": "https://proxy.example.com:443",
+	} {
+		url, err := normalizeURL(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, url)
+	}
+	for _, s := range []string{"ftp://1.2.3.4:21", "1.2.3.4", "http://:80"} {
+		_, err := normalizeURL(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestNewStaticProvider(t *testing.T) {
+	ch := NewStaticProviderWithInterval(time.Millisecond*10, "1.2.3.4:80", "", "bad", "socks5://1.2.3.4:1080", "http://1.2.3.4:80")
+	items := []Item{
+		{URL: "http://1.2.3.4:80", EnableFilter: true},
+		{URL: "socks5://1.2.3.4:1080", EnableFilter: true},
+	}
+	assert.Equal(t, items, receive(t, ch, 2))
+	// 定期重新提供
+	assert.Equal(t, items, receive(t, ch, 2))
+}
+
+// 在timeout内从ch读取n个Item
+func receive(t *testing.T, ch Provider, n int) []Item {
+	items := make([]Item, 0, n)
+	for len(items) < n {
+		select {
+		case item := <-ch:
+			items = append(items, item)
+		case <-time.After(time.Second * 3):
+			t.Fatalf("仅获得%d个代理", len(items))
+		}
+	}
+	return items
+}
+
+func TestNewFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxies.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("# 代理列表\n1.2.3.4:80\nsocks5://u:p@5.6.7.8:1080\n"), 0600))
+	ch := NewFileProvider(path, time.Millisecond*10)
+	assert.Equal(t, []Item{
+		{URL: "http://1.2.3.4:80", EnableFilter: true},
+		{URL: "socks5://u:p@5.6.7.8:1080", EnableFilter: true},
+	}, receive(t, ch, 2))
+	// 修改后移除删除的代理, 添加新增的代理
+	assert.Nil(t, os.WriteFile(path, []byte("socks5://u:p@5.6.7.8:1080\r\nhttps://9.9.9.9:443\r\n"), 0600))
+	// 跳过发现修改前重新提供的旧代理
+	var changed []Item
+	for len(changed) < 2 {
+		item := receive(t, ch, 1)[0]
+		if item.EnableFilter && item.URL != "https://9.9.9.9:443" {
+			continue
+		}
+		changed = append(changed, item)
+	}
+	assert.Equal(t, []Item{
+		{URL: "http://1.2.3.4:80", Retire: true},
+		{URL: "https://9.9.9.9:443", EnableFilter: true},
+	}, changed)
+	// 未修改时重新提供当前的代理
+	assert.ElementsMatch(t, []Item{
+		{URL: "socks5://u:p@5.6.7.8:1080", EnableFilter: true},
+		{URL: "https://9.9.9.9:443", EnableFilter: true},
+	}, receive(t, ch, 2))
+}
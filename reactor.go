@@ -1,29 +1,60 @@
 package digger
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/ReneKroon/ttlcache"
 	mapset "github.com/deckarep/golang-set"
 	"github.com/go-resty/resty/v2"
 	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/logger"
 	"github.com/spencer404/go-digger/proxy"
 	"github.com/spencer404/go-digger/storage"
+	"golang.org/x/time/rate"
 	"io"
-	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
 	"reflect"
 	"sync"
+	"syscall"
 	"time"
 )
 
-// TODO: 彩色日志，区分时间、Reactor、Spider、Error、Warning
 // 反应堆可选参数
 type ReactorOpt struct {
-	providers      []proxy.Provider
-	interval       *time.Duration
-	downloadRetry  *int
-	debug          *bool
-	proxyParallels *int
+	providers       []proxy.Provider
+	interval        *time.Duration
+	retry           *int
+	backoff         *time.Duration
+	backoffMax      *time.Duration
+	debug           *bool
+	proxyParallels  *int
+	resetOnStart    *bool
+	collectInterval *time.Duration
+	gracePeriod     *time.Duration
+	hostRates       map[string]rateRule
+	proxyRate       *rateRule
+	concurrencyMin  *int
+	concurrencyMax  *int
+	logger          logger.Logger
+	metricsAddr     *string
+	adminAddr       *string
+	middlewares     []Middleware
+	filter          storage.Filter
+	transport       *TransportOpt
+	clientFactory   ClientFactory
+	validator       *ProxyValidator
+	proxyStrategy   ProxyStrategy
+	proxyEviction   *ProxyEviction
+	timeout         *time.Duration
+	dialTimeout     *time.Duration
+	tlsVerify       *TLSVerify
+	caFiles         []string
+	certFile        string
+	keyFile         string
 }
 
 func NewReactorOpt() *ReactorOpt {
@@ -40,13 +71,164 @@ func (r *ReactorOpt) ProxyParallels(i int) *ReactorOpt {
 	return r
 }
 
+// 同一主机两次请求的最小间隔, 未通过RateLimit设置默认规则("*")时生效
 func (r *ReactorOpt) Interval(d time.Duration) *ReactorOpt {
 	r.interval = &d
 	return r
 }
 
+// 限制对host的请求速率为每秒rps个, 最多突发burst个, host为"*"时作为未单独设置的主机的默认规则
+// 主机取自item.URL, 在调用OnProcess前等待; 收到429时按Retry-After暂停该主机并降速
+// burst小于1时按1处理
+func (r *ReactorOpt) RateLimit(host string, rps float64, burst int) *ReactorOpt {
+	if r.hostRates == nil {
+		r.hostRates = make(map[string]rateRule)
+	}
+	r.hostRates[host] = newRateRule(rps, burst)
+	return r
+}
+
+// 限制每个代理的请求速率为每秒rps个, 最多突发burst个, burst小于1时按1处理
+func (r *ReactorOpt) ProxyRateLimit(rps float64, burst int) *ReactorOpt {
+	rule := newRateRule(rps, burst)
+	r.proxyRate = &rule
+	return r
+}
+
+// OnProcess返回错误后的最大重试次数, 耗尽后URL被置为失败
+func (r *ReactorOpt) Retry(i int) *ReactorOpt {
+	r.retry = &i
+	return r
+}
+
+// Deprecated: 使用Retry
 func (r *ReactorOpt) DownloadRetry(i int) *ReactorOpt {
-	r.downloadRetry = &i
+	return r.Retry(i)
+}
+
+// 重试前的等待时间, 首次为base, 之后每次翻倍, 最长为max
+func (r *ReactorOpt) RetryBackoff(base, max time.Duration) *ReactorOpt {
+	r.backoff = &base
+	r.backoffMax = &max
+	return r
+}
+
+// 启动时将进行中的URL放回等待队列, 默认开启
+// 多个进程共用同一队列时应关闭, 以免放回其他进程正在处理的URL
+func (r *ReactorOpt) ResetOnStart(enable bool) *ReactorOpt {
+	r.resetOnStart = &enable
+	return r
+}
+
+// 检查超时URL的间隔, 超时的进行中URL被放回等待队列, 默认1分钟, 为0时不检查
+func (r *ReactorOpt) CollectInterval(d time.Duration) *ReactorOpt {
+	r.collectInterval = &d
+	return r
+}
+
+// 中止后等待进行中URL完成的最长时间, 默认30秒
+func (r *ReactorOpt) GracePeriod(d time.Duration) *ReactorOpt {
+	r.gracePeriod = &d
+	return r
+}
+
+// 根据处理结果在[min, max]间自动调整并发数, 初始为parallels
+// 连续成功时逐个增加, 出错或代理被禁用, 删除时减半; 将启动max个Goroutine
+func (r *ReactorOpt) AdaptiveConcurrency(min, max int) *ReactorOpt {
+	r.concurrencyMin = &min
+	r.concurrencyMax = &max
+	return r
+}
+
+// 日志, 默认为logger.Default(); 代理Provider使用logger.Default(), 需通过logger.SetDefault设置
+func (r *ReactorOpt) Logger(l logger.Logger) *ReactorOpt {
+	r.logger = l
+	return r
+}
+
+// 运行期间在addr上以Prometheus文本格式提供指标, 路径为/metrics; 也可通过Reactor.MetricsHandler自行挂载
+func (r *ReactorOpt) Metrics(addr string) *ReactorOpt {
+	r.metricsAddr = &addr
+	return r
+}
+
+// 默认ClientFactory使用的连接参数
+func (r *ReactorOpt) Transport(opt TransportOpt) *ReactorOpt {
+	r.transport = &opt
+	return r
+}
+
+// 校验新代理, 通过后才进入代理池, 见ProxyValidator
+func (r *ReactorOpt) ValidateProxies(v ProxyValidator) *ReactorOpt {
+	r.validator = &v
+	return r
+}
+
+// 代理选取策略, 默认为LeastRecentlyUsed
+func (r *ReactorOpt) ProxyStrategy(s ProxyStrategy) *ReactorOpt {
+	r.proxyStrategy = s
+	return r
+}
+
+// 自动淘汰表现不佳的代理, 见ProxyEviction
+func (r *ReactorOpt) ProxyEviction(e ProxyEviction) *ReactorOpt {
+	r.proxyEviction = &e
+	return r
+}
+
+// 单个请求的超时时间, 默认60秒, 为负数时不限制
+func (r *ReactorOpt) Timeout(d time.Duration) *ReactorOpt {
+	r.timeout = &d
+	return r
+}
+
+// 建立连接的超时时间, 默认30秒, 为负数时不限制
+func (r *ReactorOpt) DialTimeout(d time.Duration) *ReactorOpt {
+	r.dialTimeout = &d
+	return r
+}
+
+// 证书校验策略, 默认为TLSVerifyAll, 直连与使用代理时均校验
+func (r *ReactorOpt) TLSVerify(v TLSVerify) *ReactorOpt {
+	r.tlsVerify = &v
+	return r
+}
+
+// 校验服务端证书时, 除系统CA外还信任files中的CA, 文件为PEM格式
+func (r *ReactorOpt) CACertFiles(files ...string) *ReactorOpt {
+	r.caFiles = append(r.caFiles, files...)
+	return r
+}
+
+// 客户端证书, 文件为PEM格式
+func (r *ReactorOpt) ClientCert(certFile, keyFile string) *ReactorOpt {
+	r.certFile = certFile
+	r.keyFile = keyFile
+	return r
+}
+
+// 自定义创建http.Client的方式, 设置后Transport, Timeout, DialTimeout, TLSVerify, CACertFiles与ClientCert均无效
+// OnProcess获得的resty.Client共用返回的http.Client, 不应调用SetProxy, SetTimeout等修改http.Client的方法
+func (r *ReactorOpt) ClientFactory(f ClientFactory) *ReactorOpt {
+	r.clientFactory = f
+	return r
+}
+
+// 添加作用于全部请求的中间件, 在Spider.Middlewares之前执行
+func (r *ReactorOpt) Use(m ...Middleware) *ReactorOpt {
+	r.middlewares = append(r.middlewares, m...)
+	return r
+}
+
+// 运行期间在addr上提供管理接口, 见Reactor.AdminHandler
+func (r *ReactorOpt) Admin(addr string) *ReactorOpt {
+	r.adminAddr = &addr
+	return r
+}
+
+// 在指标中输出Filter的数量, 通常与创建Queue时使用的Filter相同
+func (r *ReactorOpt) MetricsFilter(f storage.Filter) *ReactorOpt {
+	r.filter = f
 	return r
 }
 
@@ -57,22 +239,53 @@ func (r *ReactorOpt) Debug(enable bool) *ReactorOpt {
 
 // 反应堆
 type Reactor struct {
-	Queue          storage.Queue
-	Bucket         storage.Bucket
-	Interval       time.Duration
-	Retry          int
-	popErrCount    int // 队列连续弹出失败计数器
-	parallels      int // Goroutine数量
-	proxyParallels int // 代理并发量
-	providers      []proxy.Provider
-	poolCh         chan *Proxy
-	putBackList    []*Proxy
-	poolFilter     mapset.Set
-	blackList      *ttlcache.Cache
-	freezeList     freezeList
-	freezeLock     sync.Mutex
-	putBackLock    sync.Mutex
-	poolLock       sync.Mutex
+	Queue           storage.Queue
+	Bucket          storage.Bucket
+	Interval        time.Duration // 仅在创建时换算为默认限速规则
+	Retry           int
+	backoff         time.Duration // 重试退避的初始值
+	backoffMax      time.Duration // 重试退避的最大值
+	resetOnStart    bool          // 启动时是否放回进行中的URL
+	collectInterval time.Duration // 检查超时URL的间隔
+	gracePeriod     time.Duration // 中止后等待进行中URL完成的最长时间
+	limiter         *rateLimiter
+	inFlight        map[string]string // 正在处理的URL, 指纹 -> URL
+	popping         int               // 正在弹出URL的Goroutine数量
+	inFlightLock    sync.Mutex        // 保护inFlight与popping
+	settleLock      sync.RWMutex      // 更新处理结果时持有读锁, 归还全部进行中URL时持有写锁
+	finished        chan struct{}     // 队列为空且没有正在处理的URL时关闭
+	finishOnce      sync.Once
+	parallels       int           // Goroutine数量, 运行期间可调整
+	workers         int           // 正在运行的Goroutine数量
+	workerSeq       int           // 已启动的Goroutine数量, 用作编号
+	spawn           func() bool   // 运行期间启动一个新的Goroutine, 全部Goroutine已结束时返回false
+	workerLock      sync.Mutex    // 保护parallels, workers, workerSeq, spawn与Interval
+	resumed         chan struct{} // 暂停时不为nil, 恢复时关闭
+	pausedAt        time.Time     // 暂停的时间
+	pauseLock       sync.Mutex    // 保护resumed与pausedAt
+	concurrency     *aimd         // 自适应并发, 为nil时不限制
+	logger          logger.Logger // 由ReactorOpt设置的日志
+	log             logger.Logger // 运行时使用的日志, 附带Spider名称
+	metrics         *metrics
+	metricsAddr     string // 为空时不启动指标服务
+	adminAddr       string // 为空时不启动管理接口
+	middlewares     []Middleware
+	clients         *clientPool    // 按代理缓存的http.Client
+	filter          storage.Filter // 仅用于输出指标, 可为nil
+	proxyParallels  int            // 代理并发量
+	providers       []proxy.Provider
+	validator       *ProxyValidator // 为nil时不校验代理
+	proxyStrategy   ProxyStrategy
+	proxyEviction   *ProxyEviction // 为nil时不淘汰代理
+	pool            *proxyPool     // 空闲代理
+	putBackList     []*Proxy
+	poolFilter      mapset.Set
+	blackList       *ttlcache.Cache
+	retired         mapset.Set // 已被Provider移除的代理地址
+	freezeList      freezeList
+	freezeLock      sync.Mutex
+	putBackLock     sync.Mutex
+	poolLock        sync.Mutex
 }
 
 func (r *Reactor) MustRun(spider *Spider) {
@@ -85,63 +298,160 @@ func (r *Reactor) makeProxy(item *storage.QueueItem) (*Proxy, error) {
 	if r.providers == nil {
 		return nil, nil
 	}
-	log.Printf("正在申请代理")
+	r.log.Debug("正在申请代理", "url", item.URL)
 	r.poolLock.Lock()
 	defer r.poolLock.Unlock()
-	m := len(r.poolCh) + 1
+	m := r.pool.Len() + 1
 	for i := 0; i < m; i++ {
-		select {
-		case p := <-r.poolCh:
-			log.Printf("已获得代理: %s", p.URL)
-			// 若在黑名单中，则丢弃
-			if _, exist := r.blackList.Get(p.URL); exist {
-				key := fmt.Sprintf("%s:%d", p.URL, p.Index)
-				r.poolFilter.Remove(key)
-				log.Printf("获得的代理已被拉黑: %s", p.URL)
-				continue
-			}
-			// 若TTL过期，则丢弃
-			if !p.ExpiredTime.IsZero() && p.ExpiredTime.Before(time.Now()) {
-				key := fmt.Sprintf("%s:%d", p.URL, p.Index)
-				r.poolFilter.Remove(key)
-				log.Printf("获得的代理已过期: %s", p.URL)
-				continue
-			}
-			// 若在冻结列表中，也给冻结起来
-			r.freezeLock.Lock()
-			if _, exist := r.freezeList[p.URL]; exist {
-				r.freezeList[p.URL].ps = append(r.freezeList[p.URL].ps, p)
-				r.freezeLock.Unlock()
-				log.Printf("获得的代理将被冻结: %s", p.URL)
-				continue
-			}
-			r.freezeLock.Unlock()
-			// 代理正常
-			return p, nil
-		case <-time.After(time.Second * 3):
+		p := r.pool.take(time.Second * 3)
+		if p == nil {
 			return nil, errors.Errorf("获取代理超时")
 		}
+		r.log.Debug("已获得代理", "proxy", proxy.Redact(p.URL))
+		// 若在黑名单中，则丢弃
+		if _, exist := r.blackList.Get(p.URL); exist {
+			key := fmt.Sprintf("%s:%d", p.URL, p.Index)
+			r.poolFilter.Remove(key)
+			r.log.Debug("获得的代理已被拉黑", "proxy", proxy.Redact(p.URL))
+			continue
+		}
+		// 若已被Provider移除，则丢弃
+		if r.retired.Contains(p.URL) {
+			key := fmt.Sprintf("%s:%d", p.URL, p.Index)
+			r.poolFilter.Remove(key)
+			r.log.Debug("获得的代理已被移除", "proxy", proxy.Redact(p.URL))
+			continue
+		}
+		// 若TTL过期，则丢弃
+		if p.expired(time.Now()) {
+			r.discardExpired([]*Proxy{p})
+			r.log.Debug("获得的代理已过期", "proxy", proxy.Redact(p.URL))
+			continue
+		}
+		// 若在冻结列表中，也给冻结起来
+		r.freezeLock.Lock()
+		if _, exist := r.freezeList[p.URL]; exist {
+			r.freezeList[p.URL].ps = append(r.freezeList[p.URL].ps, p)
+			r.freezeLock.Unlock()
+			r.log.Debug("获得的代理将被冻结", "proxy", proxy.Redact(p.URL))
+			continue
+		}
+		r.freezeLock.Unlock()
+		// 代理正常
+		return p, nil
 	}
 	return nil, errors.Errorf("代理池忙")
 }
 
-func (r *Reactor) startProxyPool() {
-	r.poolCh = make(chan *Proxy, r.parallels*2)
-	r.putBackList = make([]*Proxy, 0)
-	r.poolFilter = mapset.NewSet()
-	r.blackList = ttlcache.NewCache()
-	r.freezeList = make(freezeList, 0)
+// 取出pool, putBackList与freezeList中满足fn的代理
+func (r *Reactor) removeProxies(fn func(*Proxy) bool) []*Proxy {
+	removed := r.pool.removeIf(fn)
+	r.putBackLock.Lock()
+	r.putBackList, removed = splitProxies(r.putBackList, fn, removed)
+	r.putBackLock.Unlock()
+	r.freezeLock.Lock()
+	for url, item := range r.freezeList {
+		item.ps, removed = splitProxies(item.ps, fn, removed)
+		if len(item.ps) == 0 {
+			delete(r.freezeList, url)
+		}
+	}
+	r.freezeLock.Unlock()
+	return removed
+}
+
+// 从代理池中移除代理, 使用中的代理在下次被获取时丢弃
+func (r *Reactor) retireProxy(url string) {
+	r.retired.Add(url)
+	r.removeProxies(func(p *Proxy) bool { return p.URL == url })
+	for i := 0; i < r.proxyParallels; i++ {
+		r.poolFilter.Remove(fmt.Sprintf("%s:%d", url, i))
+	}
+	r.clients.Remove(url)
+	r.pool.forget(url)
+	r.limiter.forget(proxyKey(url))
+}
+
+// 丢弃pool, putBackList与freezeList中已过期的代理
+func (r *Reactor) dropExpired() {
+	now := time.Now()
+	expired := r.removeProxies(func(p *Proxy) bool { return p.expired(now) })
+	if len(expired) > 0 {
+		r.discardExpired(expired)
+		r.log.Debug("已丢弃过期的代理", "count", len(expired))
+	}
+}
+
+// 将ps中满足fn的代理追加到removed, 返回剩余的代理与removed
+func splitProxies(ps []*Proxy, fn func(*Proxy) bool, removed []*Proxy) ([]*Proxy, []*Proxy) {
+	kept := ps[:0]
+	for _, p := range ps {
+		if fn(p) {
+			removed = append(removed, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	return kept, removed
+}
 
-	// 获取新代理，providers -> poolCh
+// 丢弃过期的代理, 之后Provider再次提供时可重新入队
+func (r *Reactor) discardExpired(ps []*Proxy) {
+	for _, p := range ps {
+		r.poolFilter.Remove(fmt.Sprintf("%s:%d", p.URL, p.Index))
+		r.clients.Remove(p.URL)
+		r.pool.forget(p.URL)
+		r.limiter.forget(proxyKey(p.URL))
+	}
+}
+
+// 各代理的使用统计
+func (r *Reactor) ProxyStats() map[string]ProxyStats {
+	return r.pool.Stats()
+}
+
+// 记录代理的使用结果, 达到淘汰条件时将其拉黑并返回true
+func (r *Reactor) recordProxy(p *Proxy, success bool, latency time.Duration, ph *ProxyHelper) bool {
+	banned := ph.flag == FlagForbidden || ph.flag == FlagFreeze
+	stats := r.pool.record(p.URL, success, latency, banned)
+	if r.proxyEviction == nil || !r.proxyEviction.evict(stats) {
+		return false
+	}
+	r.blackList.SetWithTTL(p.URL, struct{}{}, r.proxyEviction.Duration)
+	r.poolFilter.Remove(fmt.Sprintf("%s:%d", p.URL, p.Index))
+	r.clients.Remove(p.URL)
+	r.pool.forget(p.URL)
+	r.limiter.forget(proxyKey(p.URL))
+	return true
+}
+
+// 启动代理池, ctx被取消时相关Goroutine退出
+func (r *Reactor) startProxyPool(ctx context.Context) {
+	// 校验新代理与空闲代理
+	var validateCh chan<- []*Proxy
+	if r.validator != nil {
+		validateCh = r.startValidator(ctx)
+		if r.validator.RecheckInterval > 0 {
+			r.startRechecker(ctx)
+		}
+	}
+
+	// 获取新代理，providers -> pool
 	go func() {
-		cases := make([]reflect.SelectCase, len(r.providers))
-		for i, provider := range r.providers {
-			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(provider)}
+		// 0号case用于监听退出, 1号case用于在Provider没有新代理时定期将暂存区的代理入队
+		ticker := time.NewTicker(time.Millisecond * 100)
+		defer ticker.Stop()
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ticker.C)},
+		}
+		for _, provider := range r.providers {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(provider)})
 		}
 		for {
-			// 暂存区优先级高，先从这获取代理填充poolCh
+			// 暂存区优先级高，先从这获取代理填充pool
 			var p *Proxy
-			for {
+			for ctx.Err() == nil {
 				r.putBackLock.Lock()
 				if len(r.putBackList) == 0 {
 					r.putBackLock.Unlock()
@@ -149,50 +459,94 @@ func (r *Reactor) startProxyPool() {
 				}
 				p, r.putBackList = r.putBackList[0], r.putBackList[1:]
 				r.putBackLock.Unlock()
-				r.poolCh <- p
-				log.Printf("暂存区代理已入队: %s", p.URL)
+				if !r.pool.push(ctx, p) {
+					return
+				}
+				r.log.Debug("暂存区代理已入队", "proxy", proxy.Redact(p.URL))
 			}
-			// 暂存区满后，获取新代理填充poolCh
+			// 暂存区满后，获取新代理填充pool
 			chosen, value, ok := reflect.Select(cases)
+			if chosen == 0 {
+				r.log.Debug("ProxyPool已停止")
+				return
+			}
+			if chosen == 1 {
+				continue
+			}
 			// 当有proxy退出时，ok==false, chosen为cases下标
 			if !ok {
-				if len(cases) == 1 {
-					cases = make([]reflect.SelectCase, 0)
-					log.Printf("ProxyPool中已无Provider，结束运行")
-					break
+				if len(cases) == 3 {
+					r.log.Warn("ProxyPool中已无Provider，结束运行")
+					return
 				}
 				cases = append(cases[:chosen], cases[chosen+1:]...)
-				log.Printf("ProxyPool中有Provider退出")
+				r.log.Warn("ProxyPool中有Provider退出")
+				continue
 			}
 			urlItem := value.Interface().(proxy.Item)
 			url := urlItem.URL
-			log.Printf("从Provider处获得新代理: %s", url)
+			if urlItem.Retire {
+				r.retireProxy(url)
+				r.log.Info("代理已被Provider移除", "proxy", proxy.Redact(url))
+				continue
+			}
+			r.retired.Remove(url)
+			r.log.Debug("从Provider处获得新代理", "proxy", proxy.Redact(url))
+			if !urlItem.ExpiredTime.IsZero() && !urlItem.ExpiredTime.After(time.Now()) {
+				r.log.Debug("新代理已过期, 未能入队", "proxy", proxy.Redact(url))
+				continue
+			}
 			if _, exist := r.blackList.Get(url); exist {
-				log.Printf("新代理在黑名单中, 未能入队: %s", url)
+				r.log.Debug("新代理在黑名单中, 未能入队", "proxy", proxy.Redact(url))
 				continue
 			}
+			ps := make([]*Proxy, 0, r.proxyParallels)
 			for i := 0; i < r.proxyParallels; i++ {
 				key := fmt.Sprintf("%s:%d", url, i)
 				if urlItem.EnableFilter && r.poolFilter.Contains(key) {
-					log.Printf("新代理重复, 未能入队: %s", key)
+					r.log.Debug("新代理重复, 未能入队", "proxy", proxy.Redact(url), "index", i)
 					continue
 				}
 				r.poolFilter.Add(key)
-				r.poolCh <- &Proxy{
+				ps = append(ps, &Proxy{
 					URL:         url,
 					Index:       i,
 					CreateTime:  time.Now(),
-					ExpiredTime: time.Time{},
+					ExpiredTime: urlItem.ExpiredTime,
+				})
+			}
+			if len(ps) == 0 {
+				continue
+			}
+			// 需校验时交由校验Goroutine入队
+			if validateCh != nil {
+				select {
+				case validateCh <- ps:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			for _, p := range ps {
+				if !r.pool.push(ctx, p) {
+					return
 				}
-				log.Printf("新代理已入队: %s:%d", url, i)
+				r.log.Debug("新代理已入队", "proxy", proxy.Redact(url), "index", p.Index)
 			}
 
 		}
 	}()
 
-	// 扫描冻结列表，freezeList -> putBackList
+	// 扫描冻结列表，freezeList -> putBackList, 并丢弃已过期的代理
 	go func() {
-		for ; ; time.Sleep(time.Second) {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
 			r.freezeLock.Lock()
 			for url, item := range r.freezeList {
 				if item.t.Before(time.Now()) {
@@ -200,21 +554,250 @@ func (r *Reactor) startProxyPool() {
 						r.putBackLock.Lock()
 						r.putBackList = append(r.putBackList, p)
 						r.putBackLock.Unlock()
-						log.Printf("代理已解冻: %s", p.URL)
+						r.log.Debug("代理已解冻", "proxy", proxy.Redact(p.URL))
 					}
 					delete(r.freezeList, url)
 				}
 			}
 			r.freezeLock.Unlock()
+			r.dropExpired()
 		}
 	}()
 }
 
-// 启动爬虫
-// TODO: 启动时将进行态URL置为等待态
-// TODO: 使用支持日志等级的日志模块
-// TODO: DownloadRetry变为Retry，根据OnProcess返回值决定是否Retry
+// 第attempts次重试前的等待时间
+func (r *Reactor) retryDelay(attempts int) time.Duration {
+	d := r.backoff
+	for i := 1; i < attempts && d < r.backoffMax; i++ {
+		d *= 2
+	}
+	if d > r.backoffMax {
+		d = r.backoffMax
+	}
+	return d
+}
+
+// 处理失败的URL, 未超出重试次数时以priority放回队列, delay后可弹出, 否则置为失败
+func (r *Reactor) retry(item *storage.QueueItem, priority storage.Priority, delay time.Duration, reason error) {
+	if item.Attempts < r.Retry {
+		if _, err := r.Queue.Retry(item.Key, priority, delay); err != nil {
+			r.log.Error("放回队列失败", "url", item.URL, "error", err)
+			return
+		}
+		r.log.Info("等待重试", "url", item.URL, "attempts", item.Attempts+1, "delay", delay)
+		return
+	}
+	if _, err := r.Queue.Fail(item.Key, reason.Error()); err != nil {
+		r.log.Error("标记失败时出错", "url", item.URL, "error", err)
+		return
+	}
+	r.log.Warn("重试次数已耗尽", "url", item.URL, "error", reason)
+}
+
+// 按OnProcess返回的错误决定URL与代理的去向, 见ErrSkip
+// 返回非nil时应中止爬虫
+func (r *Reactor) handleError(item *storage.QueueItem, err error, ph *ProxyHelper, withProxy bool) error {
+	var (
+		retryLater *RetryLaterError
+		requeue    *RequeueError
+		banned     *ProxyBannedError
+		fatal      *FatalError
+		stop       error
+	)
+	proxyFlag, flagD := flag(FlagPutBack), time.Duration(0)
+	switch {
+	case errors.Is(err, ErrSkip):
+		r.log.Info("跳过", "url", item.URL, "error", err)
+		if _, err := r.Queue.Finish(item.Key); err != nil {
+			r.log.Error("从队列移除失败", "url", item.URL, "error", err)
+		}
+	case errors.As(err, &fatal):
+		r.log.Error("致命错误", "url", item.URL, "error", err)
+		r.release(item.Key, item.URL)
+		stop = fatal
+	case errors.As(err, &banned) && withProxy:
+		r.log.Warn("代理被封禁", "url", item.URL, "error", err)
+		proxyFlag, flagD = FlagForbidden, banned.Duration
+		r.release(item.Key, item.URL)
+	case errors.As(err, &retryLater):
+		r.log.Warn("执行失败", "url", item.URL, "error", err)
+		r.retry(item, item.Priority, retryLater.Delay, err)
+	case errors.As(err, &requeue):
+		r.log.Warn("执行失败", "url", item.URL, "error", err)
+		r.retry(item, requeue.Priority, 0, err)
+	default:
+		r.log.Warn("执行失败", "url", item.URL, "error", err)
+		proxyFlag = FlagDelete
+		r.retry(item, item.Priority, r.retryDelay(item.Attempts+1), err)
+	}
+	if ph.flag == FlagUnset {
+		ph.flag, ph.flagD = proxyFlag, flagD
+	}
+	return stop
+}
+
+// 等待中(含退避中)的URL数量
+func (r *Reactor) waitingLength() (int, error) {
+	lengths, err := r.Queue.Length(storage.StateWaiting)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, l := range lengths {
+		n += l
+	}
+	return n, nil
+}
+
+// 定期将超时的进行中URL放回等待队列, 直到ctx被取消
+func (r *Reactor) startCollector(ctx context.Context) {
+	if r.collectInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(r.collectInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// 本进程正在处理的URL不应被放回
+			if err := r.touchInFlight(); err != nil {
+				r.log.Error("刷新进行中的URL失败", "error", err)
+				continue
+			}
+			items, err := r.Queue.Collect()
+			if err != nil {
+				r.log.Error("放回超时URL失败", "error", err)
+				continue
+			}
+			for _, item := range items {
+				r.log.Warn("已放回超时URL", "url", item.URL)
+			}
+		}
+	}()
+}
+
+// 弹出URL并记录为正在处理
+// 弹出与记录之间其他Goroutine不会判定爬取结束; 队列为空, 没有正在弹出或处理的URL, 且无等待中的URL时关闭finished
+func (r *Reactor) pop() (storage.QueueItem, error) {
+	r.inFlightLock.Lock()
+	r.popping++
+	r.inFlightLock.Unlock()
+	item, err := r.Queue.Pop()
+	r.inFlightLock.Lock()
+	defer r.inFlightLock.Unlock()
+	r.popping--
+	if err == nil {
+		r.inFlight[item.Key] = item.URL
+		return item, nil
+	}
+	if err == io.EOF && r.popping == 0 && len(r.inFlight) == 0 {
+		// 正在处理的URL在untrack前已将新URL加入队列, 此时检查等待中的URL不会遗漏
+		if n, err := r.waitingLength(); err != nil {
+			r.log.Error("查询队列长度失败", "error", err)
+		} else if n == 0 {
+			r.finishOnce.Do(func() { close(r.finished) })
+		}
+	}
+	return item, err
+}
+
+// 处理结束后移除记录, 须在Finish, Retry等更新队列的操作之后调用
+func (r *Reactor) untrack(item *storage.QueueItem) {
+	r.inFlightLock.Lock()
+	delete(r.inFlight, item.Key)
+	r.inFlightLock.Unlock()
+}
+
+// 刷新正在处理的URL的超时计时
+func (r *Reactor) touchInFlight() error {
+	r.inFlightLock.Lock()
+	keys := make([]string, 0, len(r.inFlight))
+	for key := range r.inFlight {
+		keys = append(keys, key)
+	}
+	r.inFlightLock.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := r.Queue.Touch(keys...)
+	return err
+}
+
+// 将URL归还到等待队列
+func (r *Reactor) release(key, url string) {
+	if _, err := r.Queue.Release(key); err != nil {
+		r.log.Error("归还失败", "url", url, "error", err)
+		return
+	}
+	r.log.Info("已归还", "url", url)
+}
+
+// 更新URL的处理结果并移除记录
+// URL已被releaseInFlight归还时不再更新队列, 以免覆盖其他进程的处理; 返回是否已更新
+func (r *Reactor) settle(item *storage.QueueItem, update func()) bool {
+	r.settleLock.RLock()
+	defer r.settleLock.RUnlock()
+	r.inFlightLock.Lock()
+	_, exist := r.inFlight[item.Key]
+	r.inFlightLock.Unlock()
+	if !exist {
+		return false
+	}
+	update()
+	r.untrack(item)
+	return true
+}
+
+// 归还全部正在处理的URL, 返回归还数量
+// 等待正在更新处理结果的Goroutine完成, 之后返回的处理结果被忽略
+func (r *Reactor) releaseInFlight() int {
+	r.settleLock.Lock()
+	defer r.settleLock.Unlock()
+	r.inFlightLock.Lock()
+	defer r.inFlightLock.Unlock()
+	for key, url := range r.inFlight {
+		r.release(key, url)
+	}
+	n := len(r.inFlight)
+	r.inFlight = make(map[string]string)
+	return n
+}
+
+// 休眠d, ctx被取消时提前返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// 启动爬虫, 直到队列为空
 func (r *Reactor) Run(spider *Spider) error {
+	return r.RunContext(context.Background(), spider)
+}
+
+// 启动爬虫, 收到SIGINT或SIGTERM时中止
+func (r *Reactor) RunWithSignal(spider *Spider) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return r.RunContext(ctx, spider)
+}
+
+// 启动爬虫, 直到队列为空或ctx被取消
+// ctx被取消后不再弹出URL, 正在处理的URL在GracePeriod内完成, 超时未完成的URL被归还到等待队列;
+// 此时返回的错误可通过errors.Cause与ctx.Err()比较; OnProcess返回Fatal时同样中止, 返回的错误包装该Fatal
+func (r *Reactor) RunContext(ctx context.Context, spider *Spider) error {
 	// 初始化爬虫
 	// Spider字段检查与设置默认值
 	if spider.OnInit == nil {
@@ -225,9 +808,16 @@ func (r *Reactor) Run(spider *Spider) error {
 	if spider.OnProcess == nil {
 		return errors.Errorf("未设置Spider.OnProcess")
 	}
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "启动爬虫失败")
+	}
+	r.log = r.logger
+	if spider.Name != "" {
+		r.log = r.logger.With("spider", spider.Name)
+	}
 	// 设置初始化标识
 	if _, err := r.Bucket.Get("_IsInit"); err == storage.ErrNotExist {
-		log.Printf("正在执行OnInit")
+		r.log.Info("正在执行OnInit")
 		// 运行爬虫初始化函数
 		if err := spider.OnInit(r); err != nil {
 			return errors.Wrap(err, "初始化爬虫失败")
@@ -236,7 +826,7 @@ func (r *Reactor) Run(spider *Spider) error {
 			return errors.Wrap(err, "启动爬虫失败，设置'_IsInit'失败")
 		}
 		// 注入Seeders
-		log.Printf("正在注入%d个Seeders", len(spider.Seeders))
+		r.log.Info("正在注入Seeders", "count", len(spider.Seeders))
 		n := 0
 		for _, url := range spider.Seeders {
 			ok, err := r.Queue.Add(url, storage.Priority0)
@@ -247,70 +837,235 @@ func (r *Reactor) Run(spider *Spider) error {
 				n++
 			}
 		}
-		log.Printf("成功注入%d个Seeders", n)
+		r.log.Info("成功注入Seeders", "count", n)
 	} else if err != nil {
 		return errors.Wrap(err, "启动爬虫失败，未能获取到'_IsInit'")
 	}
-	r.startProxyPool()
+	// 上次运行中断时遗留的进行中URL
+	if r.resetOnStart {
+		n, err := r.Queue.Reset()
+		if err != nil {
+			return errors.Wrap(err, "启动爬虫失败，放回进行中的URL失败")
+		}
+		r.log.Info("已放回进行中的URL", "count", n)
+	}
+	// OnProcess返回Fatal时中止爬虫
+	ctx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	var fatalErr error
+	var fatalLock sync.Mutex
+	abort := func(err error) {
+		fatalLock.Lock()
+		if fatalErr == nil {
+			fatalErr = err
+		}
+		fatalLock.Unlock()
+		cancelRun()
+	}
+	fatal := func() error {
+		fatalLock.Lock()
+		defer fatalLock.Unlock()
+		return fatalErr
+	}
+	// 后台Goroutine随Run返回而退出
+	bgCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.startCollector(bgCtx)
+	r.startProxyPool(bgCtx)
+	r.inFlightLock.Lock()
+	r.inFlight = make(map[string]string)
+	r.popping = 0
+	r.inFlightLock.Unlock()
+	r.finished = make(chan struct{})
+	r.finishOnce = sync.Once{}
+	if r.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", r.MetricsHandler())
+		stop, err := r.serveHTTP(r.metricsAddr, mux, "指标服务")
+		if err != nil {
+			return errors.Wrap(err, "启动指标服务失败")
+		}
+		defer stop()
+	}
+	if r.adminAddr != "" {
+		stop, err := r.serveHTTP(r.adminAddr, r.AdminHandler(), "管理接口")
+		if err != nil {
+			return errors.Wrap(err, "启动管理接口失败")
+		}
+		defer stop()
+	}
 	// 监听队列
-	loopCh := make(chan int, r.parallels)
-	loopBreak := make(map[int]bool, r.parallels)
-	for i := 0; i < r.parallels; i++ {
-		loopBreak[i] = false
+	// Goroutine数量可在运行期间通过SetParallels调整, 全部Goroutine结束时关闭stopped
+	stopped := make(chan struct{})
+	spawn := func() bool {
+		r.workerLock.Lock()
+		if r.workers == 0 && r.workerSeq > 0 {
+			r.workerLock.Unlock()
+			return false
+		}
+		r.workers++
+		r.workerSeq++
+		i := r.workerSeq
+		r.workerLock.Unlock()
 		go func(i int) {
-			log.Printf("%d号Grourine已启动", i)
+			wlog := r.log.With("worker", i)
+			wlog.Debug("Goroutine已启动")
+			popErrCount := 0 // 队列连续弹出失败计数器
+			retired := false // 因并发数降低而退出
 		QueueLoop:
-			for {
+			for ctx.Err() == nil {
+				// Goroutine多于parallels时退出
+				r.workerLock.Lock()
+				if r.workers > r.parallels {
+					r.workers--
+					retired = true
+				}
+				r.workerLock.Unlock()
+				if retired {
+					break
+				}
+				// 暂停时不再弹出URL, 直到恢复或中止
+				if resumed := r.resumedCh(); resumed != nil {
+					wlog.Debug("已暂停")
+					select {
+					case <-resumed:
+					case <-ctx.Done():
+					}
+					continue
+				}
+				// 自适应并发下等待空闲名额
+				if !r.concurrency.acquire(ctx, r.finished) {
+					break
+				}
 				// 弹出Item
-				item, err := r.Queue.Pop()
+				item, err := r.pop()
 				if err == io.EOF {
-					log.Printf("%d号Grourine报告队列已空", i)
-					// 所有Goroutine要么同时运行，要么同时停止
-					// 因为队列空时，运行中的Goroutine还会继续往队列添加新的URL
-					time.Sleep(time.Second * time.Duration(r.parallels/2))
-					loopBreak[i] = true
-					for _, v := range loopBreak {
-						if !v {
-							continue QueueLoop
-						}
+					r.concurrency.release()
+					// 队列暂时为空, 其他Goroutine可能还会添加新的URL, 或仍有退避中的URL
+					select {
+					case <-r.finished:
+						wlog.Debug("队列已空")
+						break QueueLoop
+					case <-ctx.Done():
+						break QueueLoop
+					case <-time.After(time.Second):
 					}
-					break
+					continue
 				} else if err != nil {
-					r.popErrCount++
-					log.Printf("队列弹出失败: %s", err)
-					c := time.Second * time.Duration(r.popErrCount)
-					time.Sleep(c)
-					log.Printf("休眠结束: %s", c.String())
+					r.concurrency.release()
+					popErrCount++
+					wlog.Error("队列弹出失败", "error", err)
+					c := time.Second * time.Duration(popErrCount)
+					if sleepContext(ctx, c) {
+						wlog.Debug("休眠结束", "duration", c)
+					}
+					continue
+				}
+				popErrCount = 0
+				// 弹出后才被中止
+				if ctx.Err() != nil {
+					r.release(item.Key, item.URL)
+					r.untrack(&item)
+					r.concurrency.release()
+					break
+				}
+				// 主机限速, 先于获取代理, 以免等待期间占用代理
+				host := hostOf(item.URL)
+				if err := r.limiter.WaitHost(ctx, host); err != nil {
+					r.release(item.Key, item.URL)
+					r.untrack(&item)
+					r.concurrency.release()
+					if ctx.Err() != nil {
+						break
+					}
+					// 等待时间超出ctx的期限等, 稍后再试
+					wlog.Warn("等待主机限速失败", "url", item.URL, "error", err)
+					sleepContext(ctx, time.Second)
 					continue
 				}
-				loopBreak[i] = false
-				r.popErrCount = 0
 				// 客户端
 				p, err := r.makeProxy(&item)
 				if err != nil {
-					log.Printf("获取代理失败: %s", err)
+					wlog.Warn("获取代理失败", "url", item.URL, "error", err)
+					r.release(item.Key, item.URL)
+					r.untrack(&item)
+					r.concurrency.release()
 					continue
 				}
-				c := resty.New()
+				// 代理限速
+				limitKey, proxyURL := hostKey(host), ""
 				if p != nil {
-					c.SetProxy(p.URL)
-					c.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+					limitKey, proxyURL = proxyKey(p.URL), p.URL
+					if err := r.limiter.WaitProxy(ctx, proxyURL); err != nil {
+						r.release(item.Key, item.URL)
+						r.untrack(&item)
+						r.concurrency.release()
+						r.putBackLock.Lock()
+						r.putBackList = append(r.putBackList, p)
+						r.putBackLock.Unlock()
+						if ctx.Err() != nil {
+							break
+						}
+						wlog.Warn("等待代理限速失败", "url", item.URL, "proxy", proxy.Redact(proxyURL), "error", err)
+						sleepContext(ctx, time.Second)
+						continue
+					}
 				}
+				// 同一代理的URL共用连接
+				httpClient, err := r.clients.Get(proxyURL)
+				if err != nil {
+					wlog.Warn("创建客户端失败", "url", item.URL, "proxy", proxy.Redact(proxyURL), "error", err)
+					r.release(item.Key, item.URL)
+					r.untrack(&item)
+					r.concurrency.release()
+					if p != nil {
+						r.putBackLock.Lock()
+						r.putBackList = append(r.putBackList, p)
+						r.putBackLock.Unlock()
+					}
+					continue
+				}
+				c := resty.NewWithClient(httpClient)
+				// 429时暂停并降速, 使用代理时限制的是代理
+				c.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+					if resp.StatusCode() == http.StatusTooManyRequests {
+						d := retryAfter(resp)
+						wlog.Warn("请求过于频繁, 暂停", "url", resp.Request.URL, "proxy", proxy.Redact(proxyURL), "duration", d)
+						r.limiter.Slowdown(limitKey, d)
+					}
+					return nil
+				})
+				hc := &HookContext{Item: &item, Proxy: proxy.Redact(proxyURL), Logger: wlog}
+				useMiddlewares(c, hc, r.middlewares)
+				useMiddlewares(c, hc, spider.Middlewares)
 				ph := &ProxyHelper{}
 				// 交由Spider处理
-				// TODO: 重试逻辑
-				log.Printf("正在执行: %s", item.URL)
-				if err := spider.OnProcess(item.URL, c, ph, r); err != nil {
-					log.Printf("执行失败: %s, %s", item.URL, err)
-					if ph.flag == FlagUnset {
-						ph.flag = FlagDelete
+				wlog.Info("正在执行", "url", item.URL, "proxy", proxy.Redact(proxyURL))
+				start := time.Now()
+				processErr := spider.OnProcess(&item, c, ph, r)
+				elapsed := time.Since(start)
+				r.metrics.observe(elapsed, processErr)
+				settled := r.settle(&item, func() {
+					if processErr != nil {
+						if err := r.handleError(&item, processErr, ph, p != nil); err != nil {
+							abort(err)
+						}
+					} else if _, err := r.Queue.Finish(item.Key); err != nil {
+						wlog.Error("从队列移除失败", "url", item.URL, "error", err)
 					}
+				})
+				if !settled {
+					wlog.Warn("URL已在宽限期结束时归还, 忽略处理结果", "url", item.URL, "error", processErr)
 				}
-				if _, err := r.Queue.Finish(item.URL); err != nil {
-					log.Printf("从队列移除%q失败: %s", item.URL, err)
+				r.concurrency.release()
+				// 失败或代理被封禁时降低并发, 跳过的URL不计入
+				if !errors.Is(processErr, ErrSkip) {
+					r.concurrency.record(processErr == nil && ph.flag != FlagForbidden && ph.flag != FlagDelete)
 				}
 				// 处理代理
-				if p != nil {
+				if p != nil && !errors.Is(processErr, ErrSkip) && r.recordProxy(p, processErr == nil, elapsed, ph) {
+					wlog.Info("代理表现不佳, 已淘汰", "proxy", proxy.Redact(p.URL), "duration", r.proxyEviction.Duration)
+				} else if p != nil {
 					switch ph.flag {
 					case FlagPutBack, FlagUnset:
 						r.putBackLock.Lock()
@@ -329,30 +1084,215 @@ func (r *Reactor) Run(spider *Spider) error {
 						r.blackList.SetWithTTL(p.URL, struct{}{}, ph.flagD)
 						key := fmt.Sprintf("%s:%d", p.URL, p.Index)
 						r.poolFilter.Remove(key)
+						r.clients.Remove(p.URL)
+						r.pool.forget(p.URL)
+						r.limiter.forget(proxyKey(p.URL))
 					case FlagDelete:
 						key := fmt.Sprintf("%s:%d", p.URL, p.Index)
 						r.poolFilter.Remove(key)
+						r.clients.Remove(p.URL)
+						r.pool.forget(p.URL)
+						r.limiter.forget(proxyKey(p.URL))
 					default:
-						log.Printf("未支持的Flag: %d", ph.flag)
+						wlog.Error("未支持的Flag", "flag", ph.flag)
 						continue
 					}
 				}
-				// 速率控制 TODO: 精细地控制interval
-				time.Sleep(r.Interval)
 			}
-			loopCh <- i
+			wlog.Debug("Goroutine已结束")
+			if retired {
+				return
+			}
+			r.workerLock.Lock()
+			r.workers--
+			n := r.workers
+			r.workerLock.Unlock()
+			if n == 0 {
+				close(stopped)
+			}
 		}(i)
-		time.Sleep(time.Second) // 慢慢启动
+		return true
+	}
+	r.workerLock.Lock()
+	r.workers, r.workerSeq, r.spawn = 0, 0, spawn
+	r.workerLock.Unlock()
+	defer func() {
+		r.workerLock.Lock()
+		r.spawn = nil
+		r.workerLock.Unlock()
+	}()
+	for r.understaffed() {
+		if !spawn() || !sleepContext(ctx, time.Second) { // 慢慢启动
+			break
+		}
+	}
+	r.workerLock.Lock()
+	started := r.workers
+	r.workerLock.Unlock()
+	r.log.Info("Goroutine已启动", "count", started)
+	// 等待全部Goroutine结束, 中止后最多等待gracePeriod
+	done := ctx.Done()
+	var grace <-chan time.Time
+WaitLoop:
+	for {
+		select {
+		case <-stopped:
+			break WaitLoop
+		case <-done:
+			r.log.Warn("爬虫正在中止", "grace", r.gracePeriod)
+			done = nil
+			timer := time.NewTimer(r.gracePeriod)
+			defer timer.Stop()
+			grace = timer.C
+		case <-grace:
+			n := r.releaseInFlight()
+			if err := fatal(); err != nil {
+				return errors.Wrapf(err, "爬虫已中止, %d个URL未在%s内完成, 已归还到队列", n, r.gracePeriod)
+			}
+			return errors.Wrapf(ctx.Err(), "爬虫已中止, %d个URL未在%s内完成, 已归还到队列", n, r.gracePeriod)
+		}
+	}
+	if err := fatal(); err != nil {
+		return errors.Wrap(err, "爬虫已中止")
 	}
-	log.Printf("全部Groutines已启动")
-	for k := 0; k < r.parallels; k++ {
-		i := <-loopCh
-		log.Printf("%d号Grourine已结束", i)
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "爬虫已中止")
 	}
-	log.Printf("全部Groutine执行完毕")
+	r.log.Info("全部Goroutine执行完毕")
 	return nil
 }
 
+// 在addr上启动HTTP服务, 返回用于关闭服务的函数
+func (r *Reactor) serveHTTP(addr string, handler http.Handler, name string) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "监听%s失败", addr)
+	}
+	server := &http.Server{Handler: handler}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			r.log.Error(name+"异常退出", "error", err)
+		}
+	}()
+	r.log.Info(name+"已启动", "addr", ln.Addr().String())
+	return func() { _ = server.Close() }, nil
+}
+
+// 日志, 供Spider使用
+func (r *Reactor) Logger() logger.Logger {
+	return r.log
+}
+
+// 当前并发数, 未开启自适应并发时为parallels
+func (r *Reactor) Concurrency() int {
+	if r.concurrency == nil {
+		return r.Parallels()
+	}
+	return r.concurrency.Limit()
+}
+
+// Goroutine数量
+func (r *Reactor) Parallels() int {
+	r.workerLock.Lock()
+	defer r.workerLock.Unlock()
+	return r.parallels
+}
+
+// 运行期间调整并发数, 增加时立即启动新的Goroutine, 减少时多余的Goroutine处理完当前URL后退出
+// 开启自适应并发时调整的是当前并发数, 限制在[min, max]内
+func (r *Reactor) SetParallels(n int) error {
+	if n < 1 {
+		return errors.Errorf("并发数必须大于0, 当前为%d", n)
+	}
+	if r.concurrency != nil {
+		r.concurrency.setLimit(n)
+		return nil
+	}
+	r.workerLock.Lock()
+	r.parallels = n
+	spawn, lack := r.spawn, n-r.workers
+	r.workerLock.Unlock()
+	for ; spawn != nil && lack > 0; lack-- {
+		if !spawn() {
+			break
+		}
+	}
+	r.log.Info("已调整并发数", "parallels", n)
+	return nil
+}
+
+// Goroutine数量是否少于parallels
+func (r *Reactor) understaffed() bool {
+	r.workerLock.Lock()
+	defer r.workerLock.Unlock()
+	return r.workers < r.parallels
+}
+
+// 运行期间调整同一主机两次请求的最小间隔, 替换默认限速规则("*"), 为0时取消默认规则
+func (r *Reactor) SetInterval(d time.Duration) {
+	var rule *rateRule
+	if d > 0 {
+		rule = &rateRule{limit: rate.Every(d), burst: 1}
+	}
+	r.limiter.SetDefault(rule)
+	r.workerLock.Lock()
+	r.Interval = d
+	r.workerLock.Unlock()
+	r.log.Info("已调整请求间隔", "interval", d)
+}
+
+// 暂停弹出新的URL, 正在处理的URL继续完成, 代理池照常补充
+// 暂停期间Run不会因队列为空而返回, 但可被ctx中止; 在Run之前调用时, 启动后即处于暂停状态
+func (r *Reactor) Pause() {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+	if r.resumed != nil {
+		return
+	}
+	r.resumed = make(chan struct{})
+	r.pausedAt = time.Now()
+	r.log.Info("爬虫已暂停")
+}
+
+// 恢复弹出URL, 等待中的Goroutine立即继续
+func (r *Reactor) Resume() {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+	if r.resumed == nil {
+		return
+	}
+	close(r.resumed)
+	r.resumed = nil
+	r.log.Info("爬虫已恢复", "paused", time.Since(r.pausedAt))
+}
+
+// 是否已暂停
+func (r *Reactor) Paused() bool {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+	return r.resumed != nil
+}
+
+// 暂停的时间, 未暂停时为零值
+func (r *Reactor) PausedAt() time.Time {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+	if r.resumed == nil {
+		return time.Time{}
+	}
+	return r.pausedAt
+}
+
+// 暂停时返回恢复时关闭的channel, 未暂停时返回nil
+func (r *Reactor) resumedCh() <-chan struct{} {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+	if r.resumed == nil {
+		return nil
+	}
+	return r.resumed
+}
+
 func MustNewReactor(queue storage.Queue, bucket storage.Bucket, parallels int, opt *ReactorOpt) *Reactor {
 	r, err := NewReactor(queue, bucket, parallels, opt)
 	if err != nil {
@@ -362,37 +1302,141 @@ func MustNewReactor(queue storage.Queue, bucket storage.Bucket, parallels int, o
 }
 
 func NewReactor(queue storage.Queue, bucket storage.Bucket, parallels int, opt *ReactorOpt) (*Reactor, error) {
+	if parallels < 1 {
+		return nil, errors.Errorf("并发数必须大于0, 当前为%d", parallels)
+	}
 	// 默认参数
 	reactor := Reactor{
-		Queue:          queue,
-		Bucket:         bucket,
-		Interval:       0,
-		Retry:          3,
-		providers:      nil,
-		parallels:      parallels,
-		proxyParallels: 1,
+		Queue:           queue,
+		Bucket:          bucket,
+		Interval:        0,
+		Retry:           3,
+		backoff:         time.Second,
+		backoffMax:      time.Minute,
+		resetOnStart:    true,
+		collectInterval: time.Minute,
+		gracePeriod:     time.Second * 30,
+		providers:       nil,
+		parallels:       parallels,
+		proxyParallels:  1,
+		logger:          logger.Default(),
+		metrics:         newMetrics(),
 	}
 	// 可选参数
 	if opt.providers != nil {
 		reactor.providers = opt.providers
 	}
+	if opt.logger != nil {
+		reactor.logger = opt.logger
+	}
+	reactor.log = reactor.logger
 	if opt.interval != nil {
 		reactor.Interval = *opt.interval
 	}
-	if opt.downloadRetry != nil {
-		reactor.Retry = *opt.downloadRetry
+	if opt.retry != nil {
+		reactor.Retry = *opt.retry
+	}
+	if opt.backoff != nil {
+		reactor.backoff = *opt.backoff
+	}
+	if opt.backoffMax != nil {
+		reactor.backoffMax = *opt.backoffMax
 	}
 	if opt.proxyParallels != nil {
 		reactor.proxyParallels = *opt.proxyParallels
 	}
+	if opt.resetOnStart != nil {
+		reactor.resetOnStart = *opt.resetOnStart
+	}
+	if opt.collectInterval != nil {
+		reactor.collectInterval = *opt.collectInterval
+	}
+	if opt.gracePeriod != nil {
+		reactor.gracePeriod = *opt.gracePeriod
+	}
+	if opt.metricsAddr != nil {
+		reactor.metricsAddr = *opt.metricsAddr
+	}
+	if opt.adminAddr != nil {
+		reactor.adminAddr = *opt.adminAddr
+	}
+	reactor.middlewares = opt.middlewares
+	if opt.validator != nil {
+		if opt.validator.URL == "" {
+			return nil, errors.Errorf("未设置代理校验的探测地址")
+		}
+		v := opt.validator.withDefaults()
+		reactor.validator = &v
+	}
+	reactor.proxyStrategy = opt.proxyStrategy
+	if reactor.proxyStrategy == nil {
+		reactor.proxyStrategy = LeastRecentlyUsed()
+	}
+	if opt.proxyEviction != nil {
+		e := opt.proxyEviction.withDefaults()
+		reactor.proxyEviction = &e
+	}
+	factory := opt.clientFactory
+	if factory == nil {
+		transport := TransportOpt{}
+		if opt.transport != nil {
+			transport = *opt.transport
+		}
+		if opt.timeout != nil {
+			transport.Timeout = *opt.timeout
+		}
+		if opt.dialTimeout != nil {
+			transport.DialTimeout = *opt.dialTimeout
+		}
+		if opt.tlsVerify != nil {
+			transport.TLSVerify = *opt.tlsVerify
+		}
+		if len(opt.caFiles) > 0 {
+			pool, err := loadCAs(opt.caFiles...)
+			if err != nil {
+				return nil, err
+			}
+			transport.RootCAs = pool
+		}
+		if opt.certFile != "" {
+			cert, err := tls.LoadX509KeyPair(opt.certFile, opt.keyFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "读取客户端证书%q失败", opt.certFile)
+			}
+			transport.Certificates = append(transport.Certificates, cert)
+		}
+		factory = NewClientFactory(transport)
+	}
+	reactor.clients = newClientPool(factory)
+	reactor.filter = opt.filter
+	if opt.concurrencyMin != nil && opt.concurrencyMax != nil {
+		reactor.concurrency = newAIMD(*opt.concurrencyMin, *opt.concurrencyMax, parallels)
+		reactor.parallels = reactor.concurrency.max
+	}
+	// 未设置默认规则时, 由Interval换算
+	hostRates := make(map[string]rateRule)
+	for host, rule := range opt.hostRates {
+		hostRates[host] = rule
+	}
+	if _, exist := hostRates["*"]; !exist && reactor.Interval > 0 {
+		hostRates["*"] = rateRule{limit: rate.Every(reactor.Interval), burst: 1}
+	}
+	reactor.limiter = newRateLimiter(hostRates, opt.proxyRate)
+	// 代理池在此创建而非Run时, 使管理接口与指标可随时读取
+	reactor.pool = newProxyPool(reactor.parallels*2, reactor.proxyStrategy)
+	reactor.putBackList = make([]*Proxy, 0)
+	reactor.poolFilter = mapset.NewSet()
+	reactor.blackList = ttlcache.NewCache()
+	reactor.retired = mapset.NewSet()
+	reactor.freezeList = make(freezeList, 0)
 	// 调试模式, 清空资源
 	if opt.debug != nil && *opt.debug {
-		log.Println("进入调试模式")
-		log.Println("正在清空queue")
+		reactor.log.Info("进入调试模式")
+		reactor.log.Info("正在清空queue")
 		if err := queue.Truncate(); err != nil {
 			return nil, errors.Wrapf(err, "清空queue失败")
 		}
-		log.Println("正在清空bucket")
+		reactor.log.Info("正在清空bucket")
 		if err := bucket.Truncate(); err != nil {
 			return nil, errors.Wrapf(err, "清空bucket失败")
 		}
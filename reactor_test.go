@@ -0,0 +1,532 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/storage"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试用的内存Bucket与Filter
+type memStore struct {
+	data map[string]string
+	lock sync.Mutex
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string]string)}
+}
+
+func (m *memStore) Set(key string, value string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *memStore) Get(key string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if v, exist := m.data[key]; exist {
+		return v, nil
+	}
+	return "", storage.ErrNotExist
+}
+
+func (m *memStore) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exist := m.data[key]; !exist {
+		return storage.ErrNotExist
+	}
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memStore) Keys() ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memStore) Truncate() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data = make(map[string]string)
+	return nil
+}
+
+func (m *memStore) Insert(url string) error {
+	return m.Set(url, "")
+}
+
+func (m *memStore) Lookup(url string) (bool, error) {
+	_, err := m.Get(url)
+	if err == storage.ErrNotExist {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *memStore) Count() (uint, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return uint(len(m.data)), nil
+}
+
+// 弹出时随机延迟或失败的队列, 用于放大调度中的竞争
+type flakyQueue struct {
+	storage.Queue
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func (f *flakyQueue) Pop() (storage.QueueItem, error) {
+	f.lock.Lock()
+	n := f.rand.Intn(10)
+	f.lock.Unlock()
+	time.Sleep(time.Millisecond * time.Duration(n))
+	if n == 0 {
+		return storage.QueueItem{}, errors.New("弹出失败")
+	}
+	return f.Queue.Pop()
+}
+
+func newTestReactor(t *testing.T, parallels int, opt *ReactorOpt) *Reactor {
+	queue := storage.NewMemQueue(newMemStore(), time.Minute)
+	r, err := NewReactor(queue, newMemStore(), parallels, opt)
+	assert.Nil(t, err)
+	return r
+}
+
+func TestReactor_Run(t *testing.T) {
+	r := newTestReactor(t, 2, NewReactorOpt())
+	seeders := make([]string, 10)
+	for i := range seeders {
+		seeders[i] = fmt.Sprintf("s%d", i)
+	}
+	var lock sync.Mutex
+	processed := make([]string, 0)
+	spider := &Spider{
+		Seeders: seeders,
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			lock.Lock()
+			processed = append(processed, item.URL)
+			lock.Unlock()
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Len(t, processed, len(seeders))
+}
+
+func TestReactor_RunContext_Cancel(t *testing.T) {
+	r := newTestReactor(t, 2, NewReactorOpt().GracePeriod(time.Millisecond*500))
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 2)
+	spider := &Spider{
+		Seeders: []string{"slow", "stuck", "s0", "s1", "s2"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			started <- struct{}{}
+			switch item.URL {
+			case "slow": // 在宽限期内完成
+				time.Sleep(time.Millisecond * 200)
+			case "stuck": // 超出宽限期
+				time.Sleep(time.Second * 2)
+			}
+			return nil
+		},
+	}
+	go func() {
+		<-started
+		<-started
+		cancel()
+	}()
+	err := r.RunContext(ctx, spider)
+	assert.NotNil(t, err)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	// 已完成的被移除, 未完成的被归还, 未处理的仍在等待
+	state, err := r.Queue.Lookup("slow")
+	assert.Nil(t, err)
+	assert.Equal(t, storage.StateNotExist, state)
+	for _, url := range []string{"stuck", "s0", "s1", "s2"} {
+		state, err := r.Queue.Lookup(url)
+		assert.Nil(t, err)
+		assert.Equal(t, storage.StateWaiting, state, url)
+	}
+}
+
+func TestReactor_RunContext_Cancel_Late(t *testing.T) {
+	// 忽略ctx的OnProcess在Run返回后才结束, 其结果不影响已被其他进程弹出的URL
+	r := newTestReactor(t, 1, NewReactorOpt().GracePeriod(time.Millisecond*50))
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 1)
+	popped := make(chan struct{})
+	returned := make(chan struct{})
+	spider := &Spider{
+		Seeders: []string{"stuck"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			defer close(returned)
+			started <- struct{}{}
+			<-popped
+			return nil
+		},
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+	assert.NotNil(t, r.RunContext(ctx, spider))
+	// 归还后被其他进程弹出
+	item, err := r.Queue.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "stuck", item.URL)
+	close(popped)
+	<-returned
+	time.Sleep(time.Millisecond * 50)
+	state, err := r.Queue.Lookup("stuck")
+	assert.Nil(t, err)
+	assert.Equal(t, storage.StateProcessing, state)
+}
+
+func TestReactor_RunContext_Canceled(t *testing.T) {
+	r := newTestReactor(t, 1, NewReactorOpt())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	spider := &Spider{
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			return nil
+		},
+	}
+	err := r.RunContext(ctx, spider)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+}
+
+func TestReactor_Run_Stress(t *testing.T) {
+	// 每个URL派生fanout个子URL, 直到depth层
+	const (
+		seeders = 3
+		fanout  = 3
+		depth   = 3
+	)
+	queue := &flakyQueue{
+		Queue: storage.NewMemQueue(newMemStore(), time.Minute),
+		rand:  rand.New(rand.NewSource(1)),
+	}
+	r, err := NewReactor(queue, newMemStore(), 4, NewReactorOpt().RetryBackoff(time.Millisecond, time.Millisecond))
+	assert.Nil(t, err)
+	var lock sync.Mutex
+	processed := make(map[string]int)
+	spider := &Spider{
+		OnInit: func(reactor *Reactor) error {
+			for i := 0; i < seeders; i++ {
+				if _, err := reactor.Queue.AddPayload(fmt.Sprintf("s%d", i), storage.Priority0, &storage.Payload{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			// 每个URL首次处理时失败一次
+			lock.Lock()
+			processed[item.URL]++
+			first := processed[item.URL] == 1
+			lock.Unlock()
+			if first {
+				return errors.New("首次处理失败")
+			}
+			time.Sleep(time.Millisecond * time.Duration(rand.Intn(5)))
+			if item.Payload.Depth >= depth {
+				return nil
+			}
+			for i := 0; i < fanout; i++ {
+				url := fmt.Sprintf("%s-%d", item.URL, i)
+				payload := &storage.Payload{Depth: item.Payload.Depth + 1, Parent: item.URL}
+				if _, err := reactor.Queue.AddPayload(url, storage.Priority1, payload); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	// 每层数量为seeders*fanout^d
+	total, level := 0, seeders
+	for d := 0; d <= depth; d++ {
+		total += level
+		level *= fanout
+	}
+	assert.Len(t, processed, total)
+	for url, n := range processed {
+		assert.Equal(t, 2, n, url)
+	}
+	for _, state := range []storage.State{storage.StateWaiting, storage.StateProcessing, storage.StateFailed} {
+		lengths, err := r.Queue.Length(state)
+		assert.Nil(t, err)
+		assert.Empty(t, lengths)
+	}
+}
+
+func TestReactor_handleError(t *testing.T) {
+	r := newTestReactor(t, 1, NewReactorOpt().Retry(1))
+	pop := func(url string) storage.QueueItem {
+		_, err := r.Queue.Add(url, storage.Priority1)
+		assert.Nil(t, err)
+		item, err := r.Queue.Pop()
+		assert.Nil(t, err)
+		assert.Equal(t, url, item.URL)
+		return item
+	}
+	state := func(url string) storage.State {
+		s, err := r.Queue.Lookup(url)
+		assert.Nil(t, err)
+		return s
+	}
+	// 跳过: 完成
+	item := pop("skip")
+	ph := &ProxyHelper{}
+	assert.Nil(t, r.handleError(&item, errors.Wrap(ErrSkip, "无效页面"), ph, true))
+	assert.Equal(t, storage.StateNotExist, state("skip"))
+	assert.Equal(t, flag(FlagPutBack), ph.flag)
+	// 稍后重试: 延迟放回
+	item = pop("later")
+	ph = &ProxyHelper{}
+	assert.Nil(t, r.handleError(&item, RetryLater(time.Hour, errors.New("限流")), ph, true))
+	assert.Equal(t, storage.StateWaiting, state("later"))
+	assert.Equal(t, flag(FlagPutBack), ph.flag)
+	// 重新入队: 修改优先级
+	item = pop("requeue")
+	assert.Nil(t, r.handleError(&item, Requeue(storage.Priority4, nil), &ProxyHelper{}, true))
+	item, err := r.Queue.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "requeue", item.URL)
+	assert.Equal(t, storage.Priority4, item.Priority)
+	assert.Equal(t, 1, item.Attempts)
+	// 重试次数耗尽后置为失败
+	assert.Nil(t, r.handleError(&item, Requeue(storage.Priority4, nil), &ProxyHelper{}, true))
+	assert.Equal(t, storage.StateFailed, state("requeue"))
+	// 代理被封禁: 禁用代理, 立即放回且不计失败次数
+	item = pop("banned")
+	ph = &ProxyHelper{}
+	assert.Nil(t, r.handleError(&item, errors.Wrap(ProxyBanned(time.Minute, nil), "请求失败"), ph, true))
+	assert.Equal(t, flag(FlagForbidden), ph.flag)
+	assert.Equal(t, time.Minute, ph.flagD)
+	item, err = r.Queue.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "banned", item.URL)
+	assert.Equal(t, 0, item.Attempts)
+	// 已设置的Flag优先
+	ph = &ProxyHelper{}
+	ph.Freeze(time.Second)
+	assert.Nil(t, r.handleError(&item, errors.New("超时"), ph, true))
+	assert.Equal(t, flag(FlagFreeze), ph.flag)
+	// 致命错误: 放回并要求中止
+	item = pop("fatal")
+	err = r.handleError(&item, Fatal(errors.New("账号被封")), &ProxyHelper{}, true)
+	assert.NotNil(t, err)
+	assert.Equal(t, storage.StateWaiting, state("fatal"))
+}
+
+func TestReactor_Run_Retry(t *testing.T) {
+	// 始终失败的URL按退避时间重试Retry次后置为失败
+	const retry = 3
+	r := newTestReactor(t, 1, NewReactorOpt().Retry(retry).RetryBackoff(time.Millisecond*50, time.Millisecond*100))
+	attempts := make([]int, 0)
+	times := make([]time.Time, 0)
+	spider := &Spider{
+		Seeders: []string{"s0"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			attempts = append(attempts, item.Attempts)
+			times = append(times, time.Now())
+			return errors.New("请求失败")
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, []int{0, 1, 2, 3}, attempts)
+	for i, min := range []time.Duration{time.Millisecond * 50, time.Millisecond * 100, time.Millisecond * 100} {
+		assert.GreaterOrEqual(t, times[i+1].Sub(times[i]), min)
+	}
+	state, err := r.Queue.Lookup("s0")
+	assert.Nil(t, err)
+	assert.Equal(t, storage.StateFailed, state)
+	failed, err := r.Queue.Length(storage.StateFailed)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failed[storage.Priority0])
+}
+
+func TestReactor_Run_ResetOnStart(t *testing.T) {
+	for _, reset := range []bool{true, false} {
+		r := newTestReactor(t, 1, NewReactorOpt().ResetOnStart(reset))
+		// 上次运行遗留的进行中URL
+		_, err := r.Queue.Add("left", storage.Priority0)
+		assert.Nil(t, err)
+		_, err = r.Queue.Pop()
+		assert.Nil(t, err)
+		processed := make([]string, 0)
+		spider := &Spider{
+			Seeders: []string{"s0"},
+			OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+				processed = append(processed, item.URL)
+				return nil
+			},
+		}
+		assert.Nil(t, r.Run(spider))
+		state, err := r.Queue.Lookup("left")
+		assert.Nil(t, err)
+		if reset {
+			assert.ElementsMatch(t, []string{"left", "s0"}, processed)
+			assert.Equal(t, storage.StateNotExist, state)
+		} else {
+			assert.Equal(t, []string{"s0"}, processed)
+			assert.Equal(t, storage.StateProcessing, state)
+		}
+	}
+}
+
+func TestReactor_Run_Collect(t *testing.T) {
+	// 处理时间超过队列超时的URL不被放回, 其他进程遗留的超时URL被放回
+	queue := storage.NewMemQueue(newMemStore(), time.Millisecond*200)
+	r, err := NewReactor(queue, newMemStore(), 2, NewReactorOpt().ResetOnStart(false).CollectInterval(time.Millisecond*50))
+	assert.Nil(t, err)
+	_, err = queue.Add("left", storage.Priority1)
+	assert.Nil(t, err)
+	_, err = queue.Pop()
+	assert.Nil(t, err)
+	var lock sync.Mutex
+	processed := make(map[string]int)
+	spider := &Spider{
+		Seeders: []string{"slow"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			lock.Lock()
+			processed[item.URL]++
+			lock.Unlock()
+			if item.URL == "slow" {
+				time.Sleep(time.Millisecond * 1500)
+			}
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, map[string]int{"slow": 1, "left": 1}, processed)
+}
+
+func TestReactor_Run_RateLimit(t *testing.T) {
+	// burst为0时按1处理, 全部URL均被处理
+	r := newTestReactor(t, 2, NewReactorOpt().RateLimit("*", 100, 0))
+	processed := int32(0)
+	spider := &Spider{
+		Seeders: []string{"http://a.com/1", "http://a.com/2", "http://a.com/3"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			atomic.AddInt32(&processed, 1)
+			return nil
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	assert.Equal(t, int32(3), processed)
+	lengths, err := r.Queue.Length(storage.StateWaiting)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, lengths[storage.Priority0])
+}
+
+func TestReactor_RunContext_Fatal(t *testing.T) {
+	r := newTestReactor(t, 1, NewReactorOpt())
+	cause := errors.New("账号被封")
+	spider := &Spider{
+		Seeders: []string{"s0", "s1"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			return Fatal(cause)
+		},
+	}
+	err := r.Run(spider)
+	assert.Equal(t, cause, errors.Cause(err))
+	var fatal *FatalError
+	assert.True(t, errors.As(err, &fatal))
+	for _, url := range spider.Seeders {
+		state, err := r.Queue.Lookup(url)
+		assert.Nil(t, err)
+		assert.Equal(t, storage.StateWaiting, state)
+	}
+}
+
+func TestReactor_Pause(t *testing.T) {
+	r := newTestReactor(t, 2, NewReactorOpt())
+	seeders := []string{"s0", "s1", "s2", "s3", "s4", "s5"}
+	var lock sync.Mutex
+	processed := make([]string, 0)
+	paused := make(chan struct{})
+	spider := &Spider{
+		Seeders: seeders,
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			lock.Lock()
+			processed = append(processed, item.URL)
+			n := len(processed)
+			lock.Unlock()
+			// 暂停后正在处理的URL继续完成
+			if n == 1 {
+				reactor.Pause()
+				close(paused)
+				time.Sleep(time.Millisecond * 200)
+			}
+			return nil
+		},
+	}
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(processed)
+	}
+	go func() {
+		<-paused
+		assert.True(t, r.Paused())
+		assert.False(t, r.PausedAt().IsZero())
+		// 暂停期间不再弹出URL
+		time.Sleep(time.Millisecond * 1500)
+		n := count()
+		assert.True(t, n <= 2, n)
+		lengths, err := r.Queue.Length(storage.StateWaiting)
+		assert.Nil(t, err)
+		assert.Equal(t, len(seeders)-n, lengths[storage.Priority0])
+		assert.Equal(t, 0, inFlight(r))
+		// 恢复后立即继续
+		r.Resume()
+		assert.False(t, r.Paused())
+		assert.True(t, r.PausedAt().IsZero())
+		time.Sleep(time.Millisecond * 200)
+		assert.True(t, count() > n)
+	}()
+	assert.Nil(t, r.Run(spider))
+	assert.Len(t, processed, len(seeders))
+}
+
+func TestReactor_Pause_Cancel(t *testing.T) {
+	r := newTestReactor(t, 1, NewReactorOpt())
+	// Run之前暂停, 启动后不处理URL, 可被中止
+	r.Pause()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	spider := &Spider{
+		Seeders: []string{"s0"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			t.Error("暂停期间不应处理URL")
+			return nil
+		},
+	}
+	err := r.RunContext(ctx, spider)
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	state, err := r.Queue.Lookup("s0")
+	assert.Nil(t, err)
+	assert.Equal(t, storage.StateWaiting, state)
+}
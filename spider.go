@@ -1,9 +1,31 @@
 package digger
 
-import "github.com/go-resty/resty/v2"
+import (
+	"github.com/go-resty/resty/v2"
+	"github.com/spencer404/go-digger/storage"
+	"strings"
+)
 
 type Spider struct {
-	Seeders   []string                                                                           // 初始URL
-	OnInit    func(reactor *Reactor) error                                                       // 首次运行时调用
-	OnProcess func(url string, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error // 从队列获取到URL时调用
+	Name        string                                                                                          // 名称, 用于日志
+	Seeders     []string                                                                                        // 初始URL
+	OnInit      func(reactor *Reactor) error                                                                    // 首次运行时调用
+	OnProcess   func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error // 从队列获取到URL时调用
+	Middlewares []Middleware                                                                                    // 注册到client上的中间件, 见Middleware
+}
+
+// 按item的附加信息(Method, Headers, Body)发送请求, 未携带附加信息时发送GET请求
+func Execute(client *resty.Client, item *storage.QueueItem) (*resty.Response, error) {
+	req := client.R()
+	method := resty.MethodGet
+	if p := item.Payload; p != nil {
+		if p.Method != "" {
+			method = strings.ToUpper(p.Method)
+		}
+		req.SetHeaders(p.Headers)
+		if p.Body != "" {
+			req.SetBody(p.Body)
+		}
+	}
+	return req.Execute(method, item.URL)
 }
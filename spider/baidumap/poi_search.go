@@ -9,13 +9,13 @@ import (
 	"github.com/spencer404/go-digger"
 	"github.com/spencer404/go-digger/storage"
 	"github.com/spencer404/go-digger/tool"
-	"log"
-	"strconv"
 	"strings"
 )
 
 const pageSize = 50
 
+const apiURL = "http://api.map.baidu.com/"
+
 type Point struct {
 	Lng float64
 	Lat float64
@@ -42,46 +42,52 @@ var headers = map[string]string{
 		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.90 Safari/537.36",
 }
 
-func makeURL(point1, point2 Point, keyword, areaCode string) string {
-	s := fmt.Sprintf("%f,%f|%f,%f|%s|%s", point1.Lng, point1.Lat, point2.Lng, point2.Lat, keyword, areaCode)
-	return s
+// 搜索区域、关键词及行政区保存在附加信息中
+func makePayload(point1, point2 Point, keyword, areaCode string, depth int) *storage.Payload {
+	return &storage.Payload{
+		Meta: map[string]interface{}{
+			"lng1":    point1.Lng,
+			"lat1":    point1.Lat,
+			"lng2":    point2.Lng,
+			"lat2":    point2.Lat,
+			"keyword": keyword,
+			"area":    areaCode,
+		},
+		Depth: depth,
+	}
 }
 
-func parseURL(url string) (point1, point2 Point, keyword, areaCode string, err error) {
-	items := strings.Split(url, "|")
-	if len(items) != 4 {
-		err = errors.Errorf("URL格式有误: %s", url)
+func parsePayload(payload *storage.Payload) (point1, point2 Point, keyword, areaCode string, err error) {
+	if payload == nil {
+		err = errors.Errorf("缺少附加信息")
 		return
 	}
-	point1, err = parsePoint(items[0])
-	if err != nil {
-		err = errors.Wrapf(err, "URL格式有误: %s", url)
+	coords := make([]float64, 4)
+	for i, key := range []string{"lng1", "lat1", "lng2", "lat2"} {
+		v, ok := payload.Meta[key].(float64)
+		if !ok {
+			err = errors.Errorf("附加信息格式有误, %q: %v", key, payload.Meta[key])
+			return
+		}
+		coords[i] = v
+	}
+	point1 = Point{coords[0], coords[1]}
+	point2 = Point{coords[2], coords[3]}
+	if keyword, err = metaString(payload, "keyword"); err != nil {
 		return
 	}
-	point2, err = parsePoint(items[1])
-	if err != nil {
-		err = errors.Wrapf(err, "URL格式有误: %s", url)
+	if areaCode, err = metaString(payload, "area"); err != nil {
 		return
 	}
-	keyword = items[2]
-	areaCode = items[3]
 	return
 }
 
-func parsePoint(s string) (Point, error) {
-	items := strings.Split(s, ",")
-	if len(items) != 2 {
-		return Point{}, errors.Errorf("Point格式有误: %s", s)
-	}
-	lng, err := strconv.ParseFloat(items[0], 10)
-	if err != nil {
-		return Point{}, errors.Errorf("Point格式有误: %s", s)
+func metaString(payload *storage.Payload, key string) (string, error) {
+	v, ok := payload.Meta[key].(string)
+	if !ok {
+		return "", errors.Errorf("附加信息格式有误, %q: %v", key, payload.Meta[key])
 	}
-	lat, err := strconv.ParseFloat(items[1], 10)
-	if err != nil {
-		return Point{}, errors.Errorf("Point格式有误: %s", s)
-	}
-	return Point{lng, lat}, nil
+	return v, nil
 }
 
 // 将矩形按长边切割为两个小矩形
@@ -159,19 +165,26 @@ func parsePOI(content *jason.Object) (poi BaiduPOI, err error) {
 
 func NewBaiduPOISearchSpider(city string, keyword string, onSave func(poi BaiduPOI)) *digger.Spider {
 	return &digger.Spider{
-		// 坐标为大陆矩形范围
-		Seeders: []string{
-			makeURL(Point{72.396497, 0.957873}, Point{138.332409, 54.684761}, keyword, city),
+		Name: "baidumap",
+		Middlewares: []digger.Middleware{
+			digger.DefaultHeaders(headers),
+			digger.ExpectStatus(200),
 		},
-		OnProcess: func(url string, client *resty.Client, reactor *digger.Reactor) error {
+		OnInit: func(reactor *digger.Reactor) error {
+			// 坐标为大陆矩形范围
+			payload := makePayload(Point{72.396497, 0.957873}, Point{138.332409, 54.684761}, keyword, city, 0)
+			_, err := reactor.Queue.AddPayload(apiURL, storage.Priority0, payload)
+			return err
+		},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *digger.ProxyHelper, reactor *digger.Reactor) error {
 			// 获取参数
-			point1, point2, keyword, areaCode, err := parseURL(url)
+			point1, point2, keyword, areaCode, err := parsePayload(item.Payload)
 			if err != nil {
-				return err
+				return errors.Wrap(digger.ErrSkip, err.Error()) // 重试无法修复
 			}
 			// 确保point2在point1右下方
 			if point1.Lng >= point2.Lng || point1.Lat >= point2.Lat {
-				log.Println("忽略错误的矩形", point1, point2)
+				reactor.Logger().Warn("忽略错误的矩形", "point1", point1, "point2", point2)
 				return nil
 			}
 			x1, y1, err := bd09mc.LL2MC(point1.Lng, point1.Lat)
@@ -197,14 +210,10 @@ func NewBaiduPOISearchSpider(city string, keyword string, onSave func(poi BaiduP
 				"callback":    "BMap._rd._cbk64211",
 				"ak":          "E4805d16520de693a3fe707cdc962045", // 百度Demo找的
 			}
-			req := client.R().SetQueryParams(params).SetHeaders(headers)
-			resp, err := req.Get("http://api.map.baidu.com/")
+			resp, err := client.R().SetQueryParams(params).Get(item.URL)
 			if err != nil {
 				return err
 			}
-			if resp.StatusCode() != 200 {
-				return errors.Errorf("接口返回异常状态码: %d", resp.StatusCode())
-			}
 			// 提取JSON数据
 			obj, err := tool.ParseJSONp(resp.String())
 			if err != nil {
@@ -212,28 +221,29 @@ func NewBaiduPOISearchSpider(city string, keyword string, onSave func(poi BaiduP
 			}
 			contents, err := obj.GetObjectArray("content")
 			if err != nil {
-				log.Println("无搜索结果")
+				reactor.Logger().Debug("无搜索结果", "url", item.URL)
 				return nil // 无搜索结果
 			}
 			// 若结果数量过多，则需细化搜索范围
 			if len(contents) >= pageSize {
-				log.Printf("结果数量:%d, 分割搜索区域", len(contents))
+				reactor.Logger().Info("结果数量过多, 分割搜索区域", "count", len(contents))
 				pointA1, pointA2, pointB1, pointB2 := splitArea(point1, point2)
-				_, err = reactor.Queue.Add(makeURL(pointA1, pointA2, keyword, areaCode), storage.Priority3)
-				if err != nil {
+				depth := item.Payload.Depth + 1
+				payloadA := makePayload(pointA1, pointA2, keyword, areaCode, depth)
+				if _, err = reactor.Queue.AddPayload(item.URL, storage.Priority3, payloadA); err != nil {
 					return err
 				}
-				_, err = reactor.Queue.Add(makeURL(pointB1, pointB2, keyword, areaCode), storage.Priority3)
-				if err != nil {
+				payloadB := makePayload(pointB1, pointB2, keyword, areaCode, depth)
+				if _, err = reactor.Queue.AddPayload(item.URL, storage.Priority3, payloadB); err != nil {
 					return err
 				}
 			}
 			// 结构化数据
-			log.Printf("结果数量:%d, 正在保存", len(contents))
+			reactor.Logger().Info("正在保存结果", "count", len(contents))
 			for _, content := range contents {
 				poi, err := parsePOI(content)
 				if err != nil {
-					log.Printf("解析POI失败: %s", err)
+					reactor.Logger().Warn("解析POI失败", "error", err)
 					continue
 				}
 				onSave(poi)
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
+// 错误信息的最大长度(字符), 超出部分被截断
+const maxErrorLength = 1000
+
+func truncateReason(reason string) string {
+	r := []rune(reason)
+	if len(r) <= maxErrorLength {
+		return reason
+	}
+	return string(r[:maxErrorLength])
+}
+
+// 导出的失败URL
+type failedRecord struct {
+	ID        int64     `json:"id"`
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	Priority  Priority  `json:"priority"`
+	Payload   *Payload  `json:"payload,omitempty"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	Failed    time.Time `json:"failed"`
+}
+
+// 将队列中全部失败的URL以JSON Lines格式写入w, 返回导出数量
+func ExportFailed(q Queue, w io.Writer) (int, error) {
+	const pageSize = 512
+	encoder := json.NewEncoder(w)
+	n := 0
+	for offset := 0; ; offset += pageSize {
+		items, err := q.Failed(offset, pageSize)
+		if err != nil {
+			return n, errors.Wrap(err, "查询失败的URL失败")
+		}
+		for _, item := range items {
+			record := failedRecord{
+				ID:        item.ID,
+				Key:       item.Key,
+				URL:       item.URL,
+				Priority:  item.Priority,
+				Payload:   item.Payload,
+				Attempts:  item.Attempts,
+				LastError: item.LastError,
+				Failed:    item.Updated,
+			}
+			if err := encoder.Encode(record); err != nil {
+				return n, errors.Wrap(err, "写入失败的URL失败")
+			}
+			n++
+		}
+		if len(items) < pageSize {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/internal"
+	"time"
+)
+
+const createLiteBucketSQL = "CREATE TABLE IF NOT EXISTS `%s` (" +
+	"`k`        VARCHAR(500) NOT NULL PRIMARY KEY," +
+	"`v`        VARCHAR(500) NOT NULL," +
+	"`created`  TIMESTAMP    NOT NULL," +
+	"`updated`  TIMESTAMP    NOT NULL)"
+
+// 基于SQLite的信息存储, 数据保存在单个文件中, 无需数据库服务
+type LiteBucket struct {
+	db        *sqlx.DB
+	tableName string
+}
+
+func (l *LiteBucket) Set(key string, value string) error {
+	now := time.Now().UTC()
+	sql := internal.SQLf("INSERT INTO %s (k, v, created, updated) VALUES (?, ?, ?, ?) "+
+		"ON CONFLICT(k) DO UPDATE SET v=excluded.v, updated=excluded.updated", l.tableName)
+	if _, err := l.db.Exec(sql, key, value, now, now); err != nil {
+		return errors.Wrapf(err, "写入记录%q失败", key)
+	}
+	return nil
+}
+
+func (l *LiteBucket) Get(key string) (value string, err error) {
+	items := make([]BucketItem, 0)
+	sql := internal.SQLf("SELECT * FROM %s WHERE k=? LIMIT 1", l.tableName)
+	if err := l.db.Select(&items, sql, key); err != nil {
+		return "", errors.Wrapf(err, "读取记录%q失败", key)
+	}
+	if len(items) == 0 {
+		return "", ErrNotExist
+	}
+	return items[0].Value, nil
+}
+
+func (l *LiteBucket) Delete(key string) error {
+	sql := internal.SQLf("DELETE FROM %s WHERE k=?", l.tableName)
+	res, err := l.db.Exec(sql, key)
+	if err != nil {
+		return errors.Wrapf(err, "删除记录%q失败", key)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrapf(err, "删除记录%q后获取影响行数失败", key)
+	} else if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+func (l *LiteBucket) Keys() ([]string, error) {
+	items := make([]BucketItem, 0)
+	sql := internal.SQLf("SELECT k FROM %s", l.tableName)
+	if err := l.db.Select(&items, sql); err != nil {
+		return nil, errors.Wrapf(err, "查询Keys失败")
+	}
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	return keys, nil
+}
+
+func (l *LiteBucket) Truncate() error {
+	sql := internal.SQLf("DELETE FROM %s", l.tableName)
+	if _, err := l.db.Exec(sql); err != nil {
+		return errors.Wrapf(err, "清空%q表失败", l.tableName)
+	}
+	return nil
+}
+
+func MustNewLiteBucket(filename string, tableName string) *LiteBucket {
+	bucket, err := NewLiteBucket(filename, tableName)
+	if err != nil {
+		panic(err)
+	}
+	return bucket
+}
+
+func NewLiteBucket(filename string, tableName string) (*LiteBucket, error) {
+	db, err := connectLite(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建LiteBucket失败")
+	}
+	// 创建Table
+	if _, err = db.Exec(internal.SQLf(createLiteBucketSQL, tableName)); err != nil {
+		return nil, errors.Wrap(err, "创建LiteBucket失败")
+	}
+	return &LiteBucket{db: db, tableName: tableName}, nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+var liteBucket Bucket
+
+func TestLiteBucket_Set_Get(t *testing.T) {
+	testBucketSetGet(t, liteBucket)
+}
+
+func TestLiteBucket_Delete(t *testing.T) {
+	testBucketDelete(t, liteBucket)
+}
+
+func TestLiteBucket_Keys(t *testing.T) {
+	testBucketKeys(t, liteBucket)
+}
+
+func init() {
+	var err error
+	dbFile := path.Join(os.TempDir(), fmt.Sprintf("digger-%d.db", time.Now().UnixNano()))
+	liteBucket, err = NewLiteBucket(dbFile, "bucket")
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/internal"
+	"io"
+	"strings"
+	"time"
+)
+
+const createLiteQueueSQL = "CREATE TABLE IF NOT EXISTS `%s` (" +
+	"`id`       INTEGER      NOT NULL PRIMARY KEY," +
+	"`fp`       VARCHAR(560) NOT NULL UNIQUE," + // URL指纹
+	"`url`      VARCHAR(500) NOT NULL," +
+	"`state`    TINYINT      NOT NULL," + // 1: 等待中; 2: 进行中; 3: 已失败;
+	"`priority` TINYINT      NOT NULL," +
+	"`payload`  TEXT         NULL," + // 附加信息, JSON
+	"`attempts` INT          NOT NULL DEFAULT 0," + // 已失败次数
+	"`ready`    TIMESTAMP    NOT NULL," + // 早于该时间时不会被弹出
+	"`last_error` VARCHAR(1000) NOT NULL DEFAULT ''," + // 最后一次失败的错误信息
+	"`created`  TIMESTAMP    NOT NULL," +
+	"`updated`  TIMESTAMP    NOT NULL)"
+
+const createLiteQueueIndexSQL = "CREATE INDEX IF NOT EXISTS `index_%s_state_priority` ON `%s` (`state`, `priority`, `id`)"
+
+// 基于SQLite的队列, 数据保存在单个文件中, 无需数据库服务
+type LiteQueue struct {
+	db          *sqlx.DB
+	tableName   string
+	timeout     time.Duration
+	filter      Filter
+	fingerprint Fingerprint
+}
+
+func (l *LiteQueue) AddDirect(url string, priority Priority) (bool, error) {
+	return l.addDirect(url, priority, nil)
+}
+
+func (l *LiteQueue) addDirect(url string, priority Priority, payload *Payload) (bool, error) {
+	now := time.Now().UTC()
+	sql := internal.SQLf(`INSERT OR IGNORE INTO %s (fp, url, state, priority, payload, ready, created, updated) `+
+		`VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, l.tableName)
+	res, err := l.db.Exec(sql, l.fingerprint(url, payload), url, StateWaiting, priority, payload, now, now, now)
+	if err != nil {
+		return false, errors.Wrap(err, "添加URL失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil // n==0时, 队列中重复
+}
+
+func (l *LiteQueue) Add(url string, priority Priority) (bool, error) {
+	return l.AddPayload(url, priority, nil)
+}
+
+func (l *LiteQueue) AddPayload(url string, priority Priority, payload *Payload) (bool, error) {
+	exist, err := l.filter.Lookup(l.fingerprint(url, payload))
+	if err != nil {
+		return false, errors.Wrapf(err, "添加URL时在Filter中查询失败")
+	}
+	if exist {
+		return false, nil
+	}
+	return l.addDirect(url, priority, payload)
+}
+
+func (l *LiteQueue) SetFingerprint(f Fingerprint) {
+	l.fingerprint = f
+}
+
+func (l *LiteQueue) Pop() (item QueueItem, err error) {
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return QueueItem{}, errors.Wrap(err, "事务未能开始")
+	}
+	defer tx.Rollback()
+	// 查询出最优先行
+	items := make([]QueueItem, 0)
+	now := time.Now().UTC()
+	sql := internal.SQLf("SELECT * FROM %s WHERE state=? AND ready<=? ORDER BY priority, id LIMIT 1", l.tableName)
+	if err := tx.Select(&items, sql, StateWaiting, now); err != nil {
+		return QueueItem{}, errors.Wrap(err, "查询URL失败")
+	}
+	if len(items) == 0 {
+		return QueueItem{}, io.EOF
+	}
+	item = items[0]
+	// 更新状态
+	item.State = StateProcessing
+	item.Updated = now
+	sql = internal.SQLf("UPDATE %s SET state=?, updated=? WHERE id=?", l.tableName)
+	if _, err := tx.Exec(sql, item.State, item.Updated, item.ID); err != nil {
+		return QueueItem{}, errors.Wrap(err, "更新URL状态失败")
+	}
+	if err := tx.Commit(); err != nil {
+		return QueueItem{}, errors.Wrap(err, "提交事务失败")
+	}
+	return item, nil
+}
+
+func (l *LiteQueue) Length(state State) (map[Priority]int, error) {
+	dest := make([]QueueItem, 0)
+	sql := internal.SQLf(`SELECT count(1) AS count, priority FROM %s WHERE state=? GROUP BY priority`, l.tableName)
+	err := l.db.Select(&dest, sql, state)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询长度信息失败")
+	}
+	res := make(map[Priority]int)
+	for _, url := range dest {
+		res[url.Priority] = url.Count
+	}
+	return res, nil
+}
+
+func (l *LiteQueue) Truncate() error {
+	sql := internal.SQLf("DELETE FROM %s", l.tableName)
+	if _, err := l.db.Exec(sql); err != nil {
+		return errors.Wrapf(err, "清空%q表失败", l.tableName)
+	}
+	if err := l.filter.Truncate(); err != nil {
+		return errors.Wrapf(err, "清空过滤器失败")
+	}
+	return nil
+}
+
+func (l *LiteQueue) Collect() ([]QueueItem, error) {
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "事务未能开始")
+	}
+	defer tx.Rollback()
+	// 寻找过期数据
+	items := make([]QueueItem, 0)
+	sql := internal.SQLf("SELECT * FROM %s WHERE state=? AND updated<? ORDER BY id", l.tableName)
+	if err := tx.Select(&items, sql, StateProcessing, time.Now().UTC().Add(-l.timeout)); err != nil {
+		return nil, errors.Wrap(err, "查询过期数据失败")
+	}
+	// 分批放回等待队列
+	const pageSize = 512
+	now := time.Now().UTC()
+	for i := 0; i < (len(items)+pageSize-1)/pageSize; i++ {
+		args := []interface{}{StateWaiting, now, now}
+		marks := make([]string, 0)
+		min := i * pageSize
+		max := (i + 1) * pageSize
+		if max > len(items) {
+			max = len(items)
+		}
+		for _, item := range items[min:max] {
+			args = append(args, item.ID)
+			marks = append(marks, "?")
+		}
+		sql := internal.SQLf("UPDATE %s SET state=?, ready=?, updated=? WHERE id IN (%s)",
+			l.tableName, strings.Join(marks, ","))
+		if _, err := tx.Exec(sql, args...); err != nil {
+			return nil, errors.Wrap(err, "放回过期数据失败")
+		}
+	}
+	// 完成
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "提交事务失败")
+	}
+	return items, nil
+}
+
+func (l *LiteQueue) Reset() (int, error) {
+	now := time.Now().UTC()
+	sql := internal.SQLf("UPDATE %s SET state=?, ready=?, updated=? WHERE state=?", l.tableName)
+	res, err := l.db.Exec(sql, StateWaiting, now, now, StateProcessing)
+	if err != nil {
+		return 0, errors.Wrap(err, "重置进行中的URL失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "获取影响行数失败")
+	}
+	return int(n), nil
+}
+
+func (l *LiteQueue) Finish(key string) (bool, error) {
+	// 添加到过滤器
+	if err := l.filter.Insert(key); err != nil {
+		return false, errors.Wrap(err, "添加到Filter失败")
+	}
+	// 从队列中删除
+	sql := internal.SQLf("DELETE FROM %s WHERE fp=?", l.tableName)
+	res, err := l.db.Exec(sql, key)
+	if err != nil {
+		return false, errors.Wrap(err, "更新数据失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil
+}
+
+func (l *LiteQueue) Retry(key string, priority Priority, delay time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	sql := internal.SQLf("UPDATE %s SET state=?, priority=?, attempts=attempts+1, ready=?, updated=? "+
+		"WHERE fp=? AND state=?", l.tableName)
+	res, err := l.db.Exec(sql, StateWaiting, priority, now.Add(delay), now, key, StateProcessing)
+	if err != nil {
+		return false, errors.Wrap(err, "重试URL失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil
+}
+
+func (l *LiteQueue) Release(key string) (bool, error) {
+	now := time.Now().UTC()
+	sql := internal.SQLf("UPDATE %s SET state=?, ready=?, updated=? WHERE fp=? AND state=?", l.tableName)
+	res, err := l.db.Exec(sql, StateWaiting, now, now, key, StateProcessing)
+	if err != nil {
+		return false, errors.Wrap(err, "归还URL失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil
+}
+
+func (l *LiteQueue) Fail(key string, reason string) (bool, error) {
+	sql := internal.SQLf("UPDATE %s SET state=?, attempts=attempts+1, last_error=?, updated=? "+
+		"WHERE fp=? AND state=?", l.tableName)
+	res, err := l.db.Exec(sql, StateFailed, truncateReason(reason), time.Now().UTC(), key, StateProcessing)
+	if err != nil {
+		return false, errors.Wrap(err, "更新URL状态失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil
+}
+
+func (l *LiteQueue) Failed(offset, limit int) ([]QueueItem, error) {
+	items := make([]QueueItem, 0)
+	sql := internal.SQLf("SELECT * FROM %s WHERE state=? ORDER BY updated, id LIMIT ? OFFSET ?", l.tableName)
+	if err := l.db.Select(&items, sql, StateFailed, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "查询失败的URL失败")
+	}
+	return items, nil
+}
+
+func (l *LiteQueue) Touch(keys ...string) (int, error) {
+	sql := internal.SQLf("UPDATE %s SET updated=? WHERE state=?", l.tableName)
+	// 分批刷新
+	const pageSize = 512
+	total := 0
+	now := time.Now().UTC()
+	for min := 0; min < len(keys); min += pageSize {
+		max := min + pageSize
+		if max > len(keys) {
+			max = len(keys)
+		}
+		args := []interface{}{now, StateProcessing}
+		marks := make([]string, 0)
+		for _, key := range keys[min:max] {
+			args = append(args, key)
+			marks = append(marks, "?")
+		}
+		res, err := l.db.Exec(sql+" AND fp IN ("+strings.Join(marks, ",")+")", args...)
+		if err != nil {
+			return total, errors.Wrap(err, "刷新进行中的URL失败")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, errors.Wrap(err, "获取影响行数失败")
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+func (l *LiteQueue) Requeue(keys ...string) (int, error) {
+	now := time.Now().UTC()
+	sql := internal.SQLf("UPDATE %s SET state=?, attempts=0, last_error='', ready=?, updated=? WHERE state=?", l.tableName)
+	if len(keys) == 0 {
+		res, err := l.db.Exec(sql, StateWaiting, now, now, StateFailed)
+		if err != nil {
+			return 0, errors.Wrap(err, "放回失败的URL失败")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, errors.Wrap(err, "获取影响行数失败")
+		}
+		return int(n), nil
+	}
+	// 分批放回
+	const pageSize = 512
+	total := 0
+	for min := 0; min < len(keys); min += pageSize {
+		max := min + pageSize
+		if max > len(keys) {
+			max = len(keys)
+		}
+		args := []interface{}{StateWaiting, now, now, StateFailed}
+		marks := make([]string, 0)
+		for _, key := range keys[min:max] {
+			args = append(args, key)
+			marks = append(marks, "?")
+		}
+		res, err := l.db.Exec(sql+" AND fp IN ("+strings.Join(marks, ",")+")", args...)
+		if err != nil {
+			return total, errors.Wrap(err, "放回失败的URL失败")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, errors.Wrap(err, "获取影响行数失败")
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+func (l *LiteQueue) Lookup(key string) (State, error) {
+	items := make([]QueueItem, 0)
+	sql := internal.SQLf("SELECT state FROM %s WHERE fp=? LIMIT 1", l.tableName)
+	if err := l.db.Select(&items, sql, key); err != nil {
+		return StateNotExist, errors.Wrap(err, "查询url状态失败")
+	}
+	if len(items) == 0 {
+		return StateNotExist, nil
+	}
+	return items[0].State, nil
+}
+
+func MustNewLiteQueue(filename string, tableName string, filter Filter, timeout time.Duration) Queue {
+	q, err := NewLiteQueue(filename, tableName, filter, timeout)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func NewLiteQueue(filename string, tableName string, filter Filter, timeout time.Duration) (Queue, error) {
+	db, err := connectLite(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建LiteQueue失败")
+	}
+	// 创建Table
+	if _, err = db.Exec(internal.SQLf(createLiteQueueSQL, tableName)); err != nil {
+		return nil, errors.Wrap(err, "创建LiteQueue失败")
+	}
+	if _, err = db.Exec(internal.SQLf(createLiteQueueIndexSQL, tableName, tableName)); err != nil {
+		return nil, errors.Wrap(err, "创建LiteQueue失败")
+	}
+	queue := &LiteQueue{
+		db:          db,
+		tableName:   tableName,
+		filter:      filter,
+		timeout:     timeout,
+		fingerprint: DefaultFingerprint,
+	}
+	return queue, nil
+}
+
+// 打开SQLite文件, 文件不存在时自动创建
+func connectLite(filename string) (*sqlx.DB, error) {
+	// WAL模式允许读写并发; immediate使事务开始即加写锁, 避免多进程同时Pop到同一行
+	dsn := "file:" + filename + "?_busy_timeout=10000&_journal_mode=WAL&_txlock=immediate"
+	db, err := sqlx.Connect("sqlite3", dsn) // Connect会执行一次Ping
+	if err != nil {
+		return nil, errors.Wrapf(err, "打开%q失败", filename)
+	}
+	// SQLite同一时刻只允许一个写者, 单连接可避免进程内的锁竞争
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
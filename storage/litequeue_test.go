@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+var liteQueue Queue
+
+func TestLiteQueue_Add(t *testing.T) {
+	testQueueAdd(t, liteQueue)
+}
+
+func TestLiteQueue_Pop(t *testing.T) {
+	testQueuePop(t, liteQueue)
+}
+
+func TestLiteQueue_Length(t *testing.T) {
+	testQueueLength(t, liteQueue)
+}
+
+func TestLiteQueue_Collect(t *testing.T) {
+	testQueueCollect(t, liteQueue, queueTimeout)
+}
+
+func TestLiteQueue_Touch(t *testing.T) {
+	testQueueTouch(t, liteQueue, queueTimeout)
+}
+
+func TestLiteQueue_Reset(t *testing.T) {
+	testQueueReset(t, liteQueue)
+}
+
+func TestLiteQueue_Lookup_Finish(t *testing.T) {
+	testQueueLookupAndFinish(t, liteQueue)
+}
+
+func TestLiteQueue_Payload(t *testing.T) {
+	testQueuePayload(t, liteQueue)
+}
+
+func TestLiteQueue_Retry_Fail(t *testing.T) {
+	testQueueRetryAndFail(t, liteQueue)
+}
+
+func TestLiteQueue_Release(t *testing.T) {
+	testQueueRelease(t, liteQueue)
+}
+
+func TestLiteQueue_DeadLetter(t *testing.T) {
+	testQueueDeadLetter(t, liteQueue)
+}
+
+func TestLiteQueue_ConcurrentPop(t *testing.T) {
+	testQueueConcurrentPop(t, liteQueue)
+}
+
+func init() {
+	tmpFile := path.Join(os.TempDir(), fmt.Sprintf("cuckoo-%d.mmap", time.Now().UnixNano()))
+	filter, err := NewCuckooFilter(tmpFile, capacity)
+	if err != nil {
+		panic(err)
+	}
+	dbFile := path.Join(os.TempDir(), fmt.Sprintf("digger-%d.db", time.Now().UnixNano()))
+	liteQueue, err = NewLiteQueue(dbFile, "queue", filter, queueTimeout)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"container/heap"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 等待中URL的最小堆, 按priority, id排序
+type memHeap []*QueueItem
+
+func (h memHeap) Len() int { return len(h) }
+
+func (h memHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority < h[j].Priority
+	}
+	return h[i].ID < h[j].ID
+}
+
+func (h memHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *memHeap) Push(x interface{}) { *h = append(*h, x.(*QueueItem)) }
+
+func (h *memHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// 内存队列, 用于测试及单进程爬取, 进程退出后数据丢失
+type MemQueue struct {
+	items       map[string]*QueueItem // 指纹 -> item
+	waiting     memHeap               // 等待中的URL, 已移出的URL在Pop时惰性丢弃
+	delayed     []*QueueItem          // 退避中的URL, 到期后移入waiting
+	lastID      int64
+	timeout     time.Duration
+	filter      Filter
+	fingerprint Fingerprint
+	lock        sync.Mutex
+}
+
+func (m *MemQueue) AddDirect(url string, priority Priority) (bool, error) {
+	return m.addDirect(url, priority, nil)
+}
+
+func (m *MemQueue) addDirect(url string, priority Priority, payload *Payload) (bool, error) {
+	key := m.fingerprint(url, payload)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exist := m.items[key]; exist {
+		return false, nil // 队列中重复
+	}
+	m.lastID++
+	now := time.Now()
+	item := &QueueItem{
+		ID:       m.lastID,
+		Key:      key,
+		URL:      url,
+		State:    StateWaiting,
+		Priority: priority,
+		Payload:  payload,
+		Ready:    now,
+		Created:  now,
+		Updated:  now,
+	}
+	m.items[key] = item
+	heap.Push(&m.waiting, item)
+	return true, nil
+}
+
+func (m *MemQueue) Add(url string, priority Priority) (bool, error) {
+	return m.AddPayload(url, priority, nil)
+}
+
+func (m *MemQueue) AddPayload(url string, priority Priority, payload *Payload) (bool, error) {
+	exist, err := m.filter.Lookup(m.fingerprint(url, payload))
+	if err != nil {
+		return false, errors.Wrapf(err, "添加URL时在Filter中查询失败")
+	}
+	if exist {
+		return false, nil
+	}
+	return m.addDirect(url, priority, payload)
+}
+
+func (m *MemQueue) SetFingerprint(f Fingerprint) {
+	m.fingerprint = f
+}
+
+func (m *MemQueue) Pop() (item QueueItem, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	// 到期的退避URL移入等待堆
+	now := time.Now()
+	delayed := m.delayed[:0]
+	for _, p := range m.delayed {
+		if current, exist := m.items[p.Key]; !exist || current != p || p.State != StateWaiting {
+			continue
+		}
+		if p.Ready.After(now) {
+			delayed = append(delayed, p)
+		} else {
+			heap.Push(&m.waiting, p)
+		}
+	}
+	m.delayed = delayed
+	for m.waiting.Len() > 0 {
+		p := heap.Pop(&m.waiting).(*QueueItem)
+		// 已被Finish或Collect的URL仍留在堆中, 需跳过
+		if current, exist := m.items[p.Key]; !exist || current != p || p.State != StateWaiting {
+			continue
+		}
+		p.State = StateProcessing
+		p.Updated = now
+		return *p, nil
+	}
+	return QueueItem{}, io.EOF
+}
+
+func (m *MemQueue) Length(state State) (map[Priority]int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := make(map[Priority]int)
+	for _, item := range m.items {
+		if item.State == state {
+			res[item.Priority]++
+		}
+	}
+	return res, nil
+}
+
+func (m *MemQueue) Truncate() error {
+	m.lock.Lock()
+	m.items = make(map[string]*QueueItem)
+	m.waiting = make(memHeap, 0)
+	m.delayed = make([]*QueueItem, 0)
+	m.lastID = 0
+	m.lock.Unlock()
+	if err := m.filter.Truncate(); err != nil {
+		return errors.Wrapf(err, "清空过滤器失败")
+	}
+	return nil
+}
+
+func (m *MemQueue) Collect() ([]QueueItem, error) {
+	return m.collect(time.Now().Add(-m.timeout)), nil
+}
+
+func (m *MemQueue) Reset() (int, error) {
+	return len(m.collect(time.Now().Add(time.Nanosecond))), nil
+}
+
+// 将早于deadline被弹出的URL放回等待堆
+func (m *MemQueue) collect(deadline time.Time) []QueueItem {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	items := make([]QueueItem, 0)
+	now := time.Now()
+	for key, old := range m.items {
+		if old.State != StateProcessing || !old.Updated.Before(deadline) {
+			continue
+		}
+		items = append(items, *old)
+		item := *old
+		item.State = StateWaiting
+		item.Ready = now
+		item.Updated = now
+		m.items[key] = &item
+		heap.Push(&m.waiting, &item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+func (m *MemQueue) Touch(keys ...string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	n := 0
+	now := time.Now()
+	for _, key := range keys {
+		if item, exist := m.items[key]; exist && item.State == StateProcessing {
+			item.Updated = now
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemQueue) Finish(key string) (bool, error) {
+	// 添加到过滤器
+	if err := m.filter.Insert(key); err != nil {
+		return false, errors.Wrap(err, "添加到Filter失败")
+	}
+	// 从队列中删除
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exist := m.items[key]; !exist {
+		return false, nil
+	}
+	delete(m.items, key)
+	return true, nil
+}
+
+func (m *MemQueue) Retry(key string, priority Priority, delay time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	old, exist := m.items[key]
+	if !exist || old.State != StateProcessing {
+		return false, nil
+	}
+	// 替换为新对象, 使堆中的旧引用失效
+	item := *old
+	item.State = StateWaiting
+	item.Priority = priority
+	item.Attempts++
+	item.Updated = time.Now()
+	item.Ready = item.Updated.Add(delay)
+	m.items[key] = &item
+	if delay > 0 {
+		m.delayed = append(m.delayed, &item)
+	} else {
+		heap.Push(&m.waiting, &item)
+	}
+	return true, nil
+}
+
+func (m *MemQueue) Release(key string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	old, exist := m.items[key]
+	if !exist || old.State != StateProcessing {
+		return false, nil
+	}
+	item := *old
+	item.State = StateWaiting
+	item.Updated = time.Now()
+	item.Ready = item.Updated
+	m.items[key] = &item
+	heap.Push(&m.waiting, &item)
+	return true, nil
+}
+
+func (m *MemQueue) Fail(key string, reason string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item, exist := m.items[key]
+	if !exist || item.State != StateProcessing {
+		return false, nil
+	}
+	item.State = StateFailed
+	item.Attempts++
+	item.LastError = truncateReason(reason)
+	item.Updated = time.Now()
+	return true, nil
+}
+
+func (m *MemQueue) Failed(offset, limit int) ([]QueueItem, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	items := make([]QueueItem, 0)
+	for _, item := range m.items {
+		if item.State == StateFailed {
+			items = append(items, *item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Updated.Equal(items[j].Updated) {
+			return items[i].Updated.Before(items[j].Updated)
+		}
+		return items[i].ID < items[j].ID
+	})
+	if offset >= len(items) {
+		return make([]QueueItem, 0), nil
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (m *MemQueue) Requeue(keys ...string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(keys) == 0 {
+		for key, item := range m.items {
+			if item.State == StateFailed {
+				keys = append(keys, key)
+			}
+		}
+	}
+	n := 0
+	now := time.Now()
+	for _, key := range keys {
+		old, exist := m.items[key]
+		if !exist || old.State != StateFailed {
+			continue
+		}
+		item := *old
+		item.State = StateWaiting
+		item.Attempts = 0
+		item.LastError = ""
+		item.Ready = now
+		item.Updated = now
+		m.items[key] = &item
+		heap.Push(&m.waiting, &item)
+		n++
+	}
+	return n, nil
+}
+
+func (m *MemQueue) Lookup(key string) (State, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if item, exist := m.items[key]; exist {
+		return item.State, nil
+	}
+	return StateNotExist, nil
+}
+
+func NewMemQueue(filter Filter, timeout time.Duration) Queue {
+	return &MemQueue{
+		items:       make(map[string]*QueueItem),
+		waiting:     make(memHeap, 0),
+		delayed:     make([]*QueueItem, 0),
+		timeout:     timeout,
+		filter:      filter,
+		fingerprint: DefaultFingerprint,
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+var memQueue Queue
+
+func TestMemQueue_Add(t *testing.T) {
+	testQueueAdd(t, memQueue)
+}
+
+func TestMemQueue_Pop(t *testing.T) {
+	testQueuePop(t, memQueue)
+}
+
+func TestMemQueue_Length(t *testing.T) {
+	testQueueLength(t, memQueue)
+}
+
+func TestMemQueue_Collect(t *testing.T) {
+	testQueueCollect(t, memQueue, queueTimeout)
+}
+
+func TestMemQueue_Touch(t *testing.T) {
+	testQueueTouch(t, memQueue, queueTimeout)
+}
+
+func TestMemQueue_Reset(t *testing.T) {
+	testQueueReset(t, memQueue)
+}
+
+func TestMemQueue_Lookup_Finish(t *testing.T) {
+	testQueueLookupAndFinish(t, memQueue)
+}
+
+func TestMemQueue_Payload(t *testing.T) {
+	testQueuePayload(t, memQueue)
+}
+
+func TestMemQueue_Retry_Fail(t *testing.T) {
+	testQueueRetryAndFail(t, memQueue)
+}
+
+func TestMemQueue_Release(t *testing.T) {
+	testQueueRelease(t, memQueue)
+}
+
+func TestMemQueue_DeadLetter(t *testing.T) {
+	testQueueDeadLetter(t, memQueue)
+}
+
+func TestMemQueue_ConcurrentPop(t *testing.T) {
+	testQueueConcurrentPop(t, memQueue)
+}
+
+func init() {
+	tmpFile := path.Join(os.TempDir(), fmt.Sprintf("cuckoo-%d.mmap", time.Now().UnixNano()))
+	filter, err := NewCuckooFilter(tmpFile, capacity)
+	if err != nil {
+		panic(err)
+	}
+	memQueue = NewMemQueue(filter, queueTimeout)
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// URL的附加信息, 随URL一同保存在队列中
+type Payload struct {
+	Method  string                 `json:"method,omitempty"`  // HTTP方法, 为空时视为GET
+	Body    string                 `json:"body,omitempty"`    // 请求体
+	Headers map[string]string      `json:"headers,omitempty"` // 请求头
+	Meta    map[string]interface{} `json:"meta,omitempty"`    // 自定义数据, 经JSON序列化保存
+	Depth   int                    `json:"depth,omitempty"`   // 爬取深度, 种子为0
+	Parent  string                 `json:"parent,omitempty"`  // 来源URL
+}
+
+// 写入数据库时序列化为JSON, nil保存为NULL
+func (p *Payload) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "序列化Payload失败")
+	}
+	return string(b), nil
+}
+
+func (p *Payload) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*p = Payload{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.Errorf("无法将%T解析为Payload", src)
+	}
+	*p = Payload{}
+	if len(b) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return errors.Wrap(err, "反序列化Payload失败")
+	}
+	return nil
+}
+
+// URL指纹, 队列与Filter以指纹去重
+type Fingerprint func(url string, payload *Payload) string
+
+// 默认指纹
+// 未携带附加信息时为URL本身; 否则为URL拼接Method, Body, Meta的摘要
+// Headers, Depth, Parent不参与计算
+func DefaultFingerprint(url string, payload *Payload) string {
+	if payload == nil {
+		return url
+	}
+	method := strings.ToUpper(payload.Method)
+	if method == "" {
+		method = "GET"
+	}
+	if method == "GET" && payload.Body == "" && len(payload.Meta) == 0 {
+		return url
+	}
+	// map序列化时key有序, 结果稳定
+	b, _ := json.Marshal(struct {
+		Method string
+		Body   string
+		Meta   map[string]interface{}
+	}{method, payload.Body, payload.Meta})
+	sum := sha1.Sum(b)
+	return url + "#" + hex.EncodeToString(sum[:])
+}
@@ -13,30 +13,81 @@ import (
 
 const createQueueSQL = "CREATE TABLE IF NOT EXISTS `%s` (" +
 	"`id`       BIGINT       NOT NULL AUTO_INCREMENT," +
+	"`fp`       VARCHAR(560) NOT NULL COMMENT 'URL指纹'," +
 	"`url`      VARCHAR(500) NOT NULL," +
-	"`state`    TINYINT      NOT NULL COMMENT '0: 等待中; 1: 进行中;'," +
+	"`state`    TINYINT      NOT NULL COMMENT '1: 等待中; 2: 进行中; 3: 已失败;'," +
 	"`priority` TINYINT      NOT NULL COMMENT '优先级'," +
+	"`payload`  TEXT         NULL     COMMENT '附加信息, JSON'," +
+	"`attempts` INT          NOT NULL DEFAULT 0 COMMENT '已失败次数'," +
+	"`ready`    TIMESTAMP(3) NOT NULL DEFAULT now(3) COMMENT '早于该时间时不会被弹出'," +
+	"`last_error` VARCHAR(1000) NOT NULL DEFAULT '' COMMENT '最后一次失败的错误信息'," +
 	"`created`  TIMESTAMP    NOT NULL DEFAULT now()," +
 	"`updated`  TIMESTAMP    NOT NULL DEFAULT now() ON UPDATE now()," +
 	"PRIMARY KEY (`id`)," +
 	"INDEX `index_priority` (`priority` ASC)," +
 	"INDEX `index_state` (`state` ASC)," +
-	"CONSTRAINT unique_url UNIQUE (`url`))" +
+	"CONSTRAINT unique_fp UNIQUE (`fp`))" +
 	"ENGINE = InnoDB DEFAULT CHARACTER SET = utf8mb4"
 
+// 旧版本创建的表中缺少的列, 启动时按顺序补齐
+// 补齐fp后以url填充, 并将唯一约束由url改为fp
+var queueMigrations = []struct {
+	column string
+	sqls   []string
+}{
+	{"fp", []string{
+		"ALTER TABLE `%s` ADD COLUMN `fp` VARCHAR(560) NOT NULL DEFAULT '' COMMENT 'URL指纹' AFTER `id`",
+		"UPDATE `%s` SET `fp` = `url`",
+		"ALTER TABLE `%s` DROP INDEX `unique_url`, ADD CONSTRAINT unique_fp UNIQUE (`fp`)",
+	}},
+	{"payload", []string{
+		"ALTER TABLE `%s` ADD COLUMN `payload` TEXT NULL COMMENT '附加信息, JSON' AFTER `priority`",
+	}},
+	{"attempts", []string{
+		"ALTER TABLE `%s` ADD COLUMN `attempts` INT NOT NULL DEFAULT 0 COMMENT '已失败次数' AFTER `payload`",
+	}},
+	{"ready", []string{
+		"ALTER TABLE `%s` ADD COLUMN `ready` TIMESTAMP(3) NOT NULL DEFAULT now(3) COMMENT '早于该时间时不会被弹出' AFTER `attempts`",
+	}},
+	{"last_error", []string{
+		"ALTER TABLE `%s` ADD COLUMN `last_error` VARCHAR(1000) NOT NULL DEFAULT '' COMMENT '最后一次失败的错误信息' AFTER `ready`",
+	}},
+}
+
+// MyQueue可选参数
+type MyQueueOpt struct {
+	skipLocked *bool
+}
+
+func NewMyQueueOpt() *MyQueueOpt {
+	return &MyQueueOpt{}
+}
+
+// 弹出时跳过被其他事务锁定的行, 使并发的Pop互不阻塞, 需MySQL 8.0+
+func (o *MyQueueOpt) SkipLocked(enable bool) *MyQueueOpt {
+	o.skipLocked = &enable
+	return o
+}
+
 type MyQueue struct {
-	db        *sqlx.DB
-	tableName string
-	timeout   time.Duration
-	filter    Filter
+	db          *sqlx.DB
+	tableName   string
+	timeout     time.Duration
+	filter      Filter
+	fingerprint Fingerprint
+	skipLocked  bool
 }
 
 func (m *MyQueue) AddDirect(url string, priority Priority) (bool, error) {
-	sql := internal.SQLf(`INSERT INTO %s (url, state, priority) VALUE (?, ?, ?)`, m.tableName)
-	_, err := m.db.Exec(sql, url, StateWaiting, priority)
+	return m.addDirect(url, priority, nil)
+}
+
+func (m *MyQueue) addDirect(url string, priority Priority, payload *Payload) (bool, error) {
+	sql := internal.SQLf(`INSERT INTO %s (fp, url, state, priority, payload) VALUE (?, ?, ?, ?, ?)`, m.tableName)
+	_, err := m.db.Exec(sql, m.fingerprint(url, payload), url, StateWaiting, priority, payload)
 	if err == nil {
 		return true, nil
-	} else if err.(*mysql.MySQLError).Number == 1062 {
+	} else if e, ok := err.(*mysql.MySQLError); ok && e.Number == 1062 {
 		return false, nil // 队列中重复
 	} else {
 		return false, errors.Wrap(err, "添加URL失败")
@@ -44,14 +95,22 @@ func (m *MyQueue) AddDirect(url string, priority Priority) (bool, error) {
 }
 
 func (m *MyQueue) Add(url string, priority Priority) (bool, error) {
-	exist, err := m.filter.Lookup(url)
+	return m.AddPayload(url, priority, nil)
+}
+
+func (m *MyQueue) AddPayload(url string, priority Priority, payload *Payload) (bool, error) {
+	exist, err := m.filter.Lookup(m.fingerprint(url, payload))
 	if err != nil {
 		return false, errors.Wrapf(err, "添加URL时在Filter中查询失败")
 	}
 	if exist {
 		return false, nil
 	}
-	return m.AddDirect(url, priority)
+	return m.addDirect(url, priority, payload)
+}
+
+func (m *MyQueue) SetFingerprint(f Fingerprint) {
+	m.fingerprint = f
 }
 
 func (m *MyQueue) Pop() (item QueueItem, err error) {
@@ -62,7 +121,11 @@ func (m *MyQueue) Pop() (item QueueItem, err error) {
 	}
 	// 查询出最优先行
 	items := make([]QueueItem, 0)
-	sql := internal.SQLf("SELECT * FROM %s WHERE state=? ORDER BY priority, id LIMIT 1 FOR UPDATE", m.tableName)
+	sql := internal.SQLf(
+		"SELECT * FROM %s WHERE state=? AND ready<=now(3) ORDER BY priority, id LIMIT 1 FOR UPDATE", m.tableName)
+	if m.skipLocked {
+		sql += " SKIP LOCKED"
+	}
 	if err := tx.Select(&items, sql, StateWaiting); err != nil {
 		return QueueItem{}, errors.Wrap(err, "查询URL失败")
 	}
@@ -106,7 +169,6 @@ func (m *MyQueue) Truncate() error {
 	return nil
 }
 
-// 清理超时URL的Goroutine
 func (m *MyQueue) Collect() ([]QueueItem, error) {
 	tx, err := m.db.Beginx()
 	defer tx.Rollback()
@@ -121,10 +183,10 @@ func (m *MyQueue) Collect() ([]QueueItem, error) {
 	if err := tx.Select(&items, sql, StateProcessing, interval); err != nil {
 		return nil, errors.Wrap(err, "查询过期数据失败")
 	}
-	// 分批删除过期数据
+	// 分批放回等待队列
 	const pageSize = 512
 	for i := 0; i < (len(items)+pageSize-1)/pageSize; i++ {
-		args := make([]interface{}, 0)
+		args := []interface{}{StateWaiting}
 		marks := make([]string, 0)
 		min := i * pageSize
 		max := (i + 1) * pageSize
@@ -135,9 +197,9 @@ func (m *MyQueue) Collect() ([]QueueItem, error) {
 			args = append(args, item.ID)
 			marks = append(marks, "?")
 		}
-		sql := internal.SQLf("DELETE FROM %s WHERE id IN (%s)", m.tableName, strings.Join(marks, ","))
+		sql := internal.SQLf("UPDATE %s SET state=?, ready=now(3) WHERE id IN (%s)", m.tableName, strings.Join(marks, ","))
 		if _, err := tx.Exec(sql, args...); err != nil {
-			return nil, errors.Wrap(err, "删除过期数据失败")
+			return nil, errors.Wrap(err, "放回过期数据失败")
 		}
 	}
 	// 完成
@@ -148,14 +210,27 @@ func (m *MyQueue) Collect() ([]QueueItem, error) {
 	return items, nil
 }
 
-func (m *MyQueue) Finish(url string) (bool, error) {
+func (m *MyQueue) Reset() (int, error) {
+	sql := internal.SQLf("UPDATE %s SET state=?, ready=now(3) WHERE state=?", m.tableName)
+	res, err := m.db.Exec(sql, StateWaiting, StateProcessing)
+	if err != nil {
+		return 0, errors.Wrap(err, "重置进行中的URL失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "获取影响行数失败")
+	}
+	return int(n), nil
+}
+
+func (m *MyQueue) Finish(key string) (bool, error) {
 	// 添加到过滤器
-	if err := m.filter.Insert(url); err != nil {
+	if err := m.filter.Insert(key); err != nil {
 		return false, errors.Wrap(err, "添加到Filter失败")
 	}
 	// 从队列中删除
-	sql := internal.SQLf("DELETE FROM %s WHERE url=?", m.tableName)
-	res, err := m.db.Exec(sql, url)
+	sql := internal.SQLf("DELETE FROM %s WHERE fp=?", m.tableName)
+	res, err := m.db.Exec(sql, key)
 	if err != nil {
 		return false, errors.Wrap(err, "更新数据失败")
 	}
@@ -166,10 +241,129 @@ func (m *MyQueue) Finish(url string) (bool, error) {
 	return n == 1, nil
 }
 
-func (m *MyQueue) Lookup(url string) (State, error) {
+func (m *MyQueue) Retry(key string, priority Priority, delay time.Duration) (bool, error) {
+	sql := internal.SQLf("UPDATE %s SET state=?, priority=?, attempts=attempts+1, "+
+		"ready=now(3) + INTERVAL ? MICROSECOND WHERE fp=? AND state=?", m.tableName)
+	interval := delay.Nanoseconds() / time.Microsecond.Nanoseconds()
+	res, err := m.db.Exec(sql, StateWaiting, priority, interval, key, StateProcessing)
+	if err != nil {
+		return false, errors.Wrap(err, "重试URL失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil
+}
+
+func (m *MyQueue) Release(key string) (bool, error) {
+	sql := internal.SQLf("UPDATE %s SET state=?, ready=now(3) WHERE fp=? AND state=?", m.tableName)
+	res, err := m.db.Exec(sql, StateWaiting, key, StateProcessing)
+	if err != nil {
+		return false, errors.Wrap(err, "归还URL失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil
+}
+
+func (m *MyQueue) Fail(key string, reason string) (bool, error) {
+	sql := internal.SQLf("UPDATE %s SET state=?, attempts=attempts+1, last_error=? WHERE fp=? AND state=?", m.tableName)
+	res, err := m.db.Exec(sql, StateFailed, truncateReason(reason), key, StateProcessing)
+	if err != nil {
+		return false, errors.Wrap(err, "更新URL状态失败")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "获取影响行数失败")
+	}
+	return n == 1, nil
+}
+
+func (m *MyQueue) Failed(offset, limit int) ([]QueueItem, error) {
+	items := make([]QueueItem, 0)
+	sql := internal.SQLf("SELECT * FROM %s WHERE state=? ORDER BY updated, id LIMIT ? OFFSET ?", m.tableName)
+	if err := m.db.Select(&items, sql, StateFailed, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "查询失败的URL失败")
+	}
+	return items, nil
+}
+
+func (m *MyQueue) Touch(keys ...string) (int, error) {
+	sql := internal.SQLf("UPDATE %s SET updated=now() WHERE state=?", m.tableName)
+	// 分批刷新
+	const pageSize = 512
+	total := 0
+	for min := 0; min < len(keys); min += pageSize {
+		max := min + pageSize
+		if max > len(keys) {
+			max = len(keys)
+		}
+		args := []interface{}{StateProcessing}
+		marks := make([]string, 0)
+		for _, key := range keys[min:max] {
+			args = append(args, key)
+			marks = append(marks, "?")
+		}
+		res, err := m.db.Exec(sql+" AND fp IN ("+strings.Join(marks, ",")+")", args...)
+		if err != nil {
+			return total, errors.Wrap(err, "刷新进行中的URL失败")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, errors.Wrap(err, "获取影响行数失败")
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+func (m *MyQueue) Requeue(keys ...string) (int, error) {
+	sql := internal.SQLf("UPDATE %s SET state=?, attempts=0, last_error='', ready=now(3) WHERE state=?", m.tableName)
+	if len(keys) == 0 {
+		res, err := m.db.Exec(sql, StateWaiting, StateFailed)
+		if err != nil {
+			return 0, errors.Wrap(err, "放回失败的URL失败")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, errors.Wrap(err, "获取影响行数失败")
+		}
+		return int(n), nil
+	}
+	// 分批放回
+	const pageSize = 512
+	total := 0
+	for min := 0; min < len(keys); min += pageSize {
+		max := min + pageSize
+		if max > len(keys) {
+			max = len(keys)
+		}
+		args := []interface{}{StateWaiting, StateFailed}
+		marks := make([]string, 0)
+		for _, key := range keys[min:max] {
+			args = append(args, key)
+			marks = append(marks, "?")
+		}
+		res, err := m.db.Exec(sql+" AND fp IN ("+strings.Join(marks, ",")+")", args...)
+		if err != nil {
+			return total, errors.Wrap(err, "放回失败的URL失败")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, errors.Wrap(err, "获取影响行数失败")
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+func (m *MyQueue) Lookup(key string) (State, error) {
 	items := make([]QueueItem, 0)
-	sql := internal.SQLf("SELECT state FROM %s WHERE url=? LIMIT 1", m.tableName)
-	if err := m.db.Select(&items, sql, url); err != nil {
+	sql := internal.SQLf("SELECT state FROM %s WHERE fp=? LIMIT 1", m.tableName)
+	if err := m.db.Select(&items, sql, key); err != nil {
 		return StateNotExist, errors.Wrap(err, "查询url状态失败")
 	}
 	if len(items) == 0 {
@@ -178,6 +372,26 @@ func (m *MyQueue) Lookup(url string) (State, error) {
 	return items[0].State, nil
 }
 
+// 为旧版本创建的表补齐缺少的列
+func migrateQueue(db *sqlx.DB, tableName string) error {
+	for _, m := range queueMigrations {
+		var n int
+		sql := "SELECT count(1) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=database() AND TABLE_NAME=? AND COLUMN_NAME=?"
+		if err := db.Get(&n, sql, tableName, m.column); err != nil {
+			return errors.Wrap(err, "查询表结构失败")
+		}
+		if n > 0 {
+			continue
+		}
+		for _, sql := range m.sqls {
+			if _, err := db.Exec(internal.SQLf(sql, tableName)); err != nil {
+				return errors.Wrapf(err, "添加列%s失败", m.column)
+			}
+		}
+	}
+	return nil
+}
+
 func MustNewMyQueue(dsn string, tableName string, filter Filter, timeout time.Duration) Queue {
 	q, err := NewMyQueue(dsn, tableName, filter, timeout)
 	if err != nil {
@@ -188,6 +402,18 @@ func MustNewMyQueue(dsn string, tableName string, filter Filter, timeout time.Du
 
 // TODO: 结构化DSN
 func NewMyQueue(dsn string, tableName string, filter Filter, timeout time.Duration) (Queue, error) {
+	return NewMyQueueWithOpt(dsn, tableName, filter, timeout, NewMyQueueOpt())
+}
+
+func MustNewMyQueueWithOpt(dsn string, tableName string, filter Filter, timeout time.Duration, opt *MyQueueOpt) Queue {
+	q, err := NewMyQueueWithOpt(dsn, tableName, filter, timeout, opt)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func NewMyQueueWithOpt(dsn string, tableName string, filter Filter, timeout time.Duration, opt *MyQueueOpt) (Queue, error) {
 	// 连接MySQL
 	dsn = dsn + "?parseTime=true"
 	db, err := sqlx.Connect("mysql", dsn) // Connect会执行一次Ping
@@ -198,5 +424,19 @@ func NewMyQueue(dsn string, tableName string, filter Filter, timeout time.Durati
 	if _, err = db.Exec(internal.SQLf(createQueueSQL, tableName)); err != nil {
 		return nil, errors.Wrap(err, "创建MyQueue失败")
 	}
-	return &MyQueue{db: db, tableName: tableName, filter: filter, timeout: timeout}, nil
+	if err = migrateQueue(db, tableName); err != nil {
+		return nil, errors.Wrap(err, "创建MyQueue失败")
+	}
+	queue := &MyQueue{
+		db:          db,
+		tableName:   tableName,
+		filter:      filter,
+		timeout:     timeout,
+		fingerprint: DefaultFingerprint,
+	}
+	// 可选参数
+	if opt.skipLocked != nil {
+		queue.skipLocked = *opt.skipLocked
+	}
+	return queue, nil
 }
@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"math/rand"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -14,6 +18,7 @@ import (
 const queueTimeout = time.Second * 5
 
 var q Queue
+var qSkipLocked Queue
 
 func mustTruncateQueue(t *testing.T, q Queue) {
 	if err := q.Truncate(); !assert.Nil(t, err) {
@@ -57,6 +62,74 @@ func testQueueCollect(t *testing.T, q Queue, timeout time.Duration) {
 	assert.EqualValues(t, "p0i0", items[0].URL)
 	assert.EqualValues(t, StateProcessing, items[0].State)
 	assert.EqualValues(t, Priority0, items[0].Priority)
+	// 已放回等待队列, 优先级不变, 可再次弹出
+	state, err := q.Lookup("p0i0")
+	assert.Nil(t, err)
+	assert.Equal(t, StateWaiting, state)
+	item, err := q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "p0i0", item.URL)
+	assert.Equal(t, Priority0, item.Priority)
+}
+
+func testQueueTouch(t *testing.T, q Queue, timeout time.Duration) {
+	mustTruncateQueue(t, q)
+	for _, url := range []string{"p0i0", "p0i1"} {
+		ok, err := q.Add(url, Priority0)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		_, err = q.Pop()
+		assert.Nil(t, err)
+	}
+	// 刷新p0i0, 不存在的URL被忽略
+	time.Sleep(timeout / 2)
+	n, err := q.Touch("p0i0", "p9i9")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	// 仅未刷新的p0i1过期
+	time.Sleep(timeout/2 + time.Second)
+	items, err := q.Collect()
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "p0i1", items[0].URL)
+	state, err := q.Lookup("p0i0")
+	assert.Nil(t, err)
+	assert.Equal(t, StateProcessing, state)
+	// 等待中的URL不被刷新
+	n, err = q.Touch("p0i1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func testQueueReset(t *testing.T, q Queue) {
+	mustTruncateQueue(t, q)
+	for _, url := range []string{"p0i0", "p0i1", "p0i2"} {
+		_, err := q.Add(url, Priority0)
+		assert.Nil(t, err)
+	}
+	_, err := q.Pop()
+	assert.Nil(t, err)
+	_, err = q.Pop()
+	assert.Nil(t, err)
+	// 未超时的进行中URL也被放回
+	n, err := q.Reset()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	processing, err := q.Length(StateProcessing)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, processing[Priority0])
+	waiting, err := q.Length(StateWaiting)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, waiting[Priority0])
+	// 按原顺序弹出
+	for _, url := range []string{"p0i0", "p0i1", "p0i2"} {
+		item, err := q.Pop()
+		assert.Nil(t, err)
+		assert.Equal(t, url, item.URL)
+	}
+	n, err = q.Reset()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
 }
 
 func testQueueLength(t *testing.T, q Queue) {
@@ -157,6 +230,237 @@ func testQueueLookupAndFinish(t *testing.T, q Queue) {
 	assert.False(t, ok)
 }
 
+func testQueueConcurrentPop(t *testing.T, q Queue) {
+	mustTruncateQueue(t, q)
+	const n = 200
+	for i := 0; i < n; i++ {
+		ok, err := q.Add(fmt.Sprintf("p%di%d", i%4, i), Priority(i%4))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	// 并发弹出, 每个URL只能被弹出一次
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	popped := make(map[string]int)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, err := q.Pop()
+				if err == io.EOF {
+					return
+				}
+				if !assert.Nil(t, err) {
+					return
+				}
+				lock.Lock()
+				popped[item.URL]++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, n, len(popped))
+	for url, count := range popped {
+		assert.Equal(t, 1, count, url)
+	}
+}
+
+func testQueuePayload(t *testing.T, q Queue) {
+	mustTruncateQueue(t, q)
+	payload := &Payload{
+		Method:  "POST",
+		Body:    "a=1",
+		Headers: map[string]string{"Referer": "p0i0"},
+		Meta:    map[string]interface{}{"lng": 1.5, "keyword": "k"},
+		Depth:   2,
+		Parent:  "p0i0",
+	}
+	key := DefaultFingerprint("p1i0", payload)
+	assert.NotEqual(t, "p1i0", key)
+	// 附加信息相同时去重
+	ok, err := q.AddPayload("p1i0", Priority1, payload)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = q.AddPayload("p1i0", Priority1, payload)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	// URL相同但附加信息不同时，视为不同的URL
+	ok, err = q.Add("p1i0", Priority0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// 弹出后附加信息完整
+	item, err := q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "p1i0", item.Key)
+	assert.Nil(t, item.Payload)
+	item, err = q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, key, item.Key)
+	assert.Equal(t, "p1i0", item.URL)
+	assert.Equal(t, payload, item.Payload)
+	// 以指纹完成后，不能再次添加
+	state, err := q.Lookup(key)
+	assert.Nil(t, err)
+	assert.Equal(t, StateProcessing, state)
+	ok, err = q.Finish(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = q.AddPayload("p1i0", Priority1, payload)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func testQueueRetryAndFail(t *testing.T, q Queue) {
+	mustTruncateQueue(t, q)
+	ok, err := q.Add("p0i0", Priority0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// 只有进行中的URL可以重试
+	ok, err = q.Retry("p0i0", Priority1, 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	item, err := q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, item.Attempts)
+	// 立即重试, 可再次弹出, 优先级被修改
+	ok, err = q.Retry("p0i0", Priority1, 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	state, err := q.Lookup("p0i0")
+	assert.Nil(t, err)
+	assert.Equal(t, StateWaiting, state)
+	item, err = q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "p0i0", item.URL)
+	assert.Equal(t, 1, item.Attempts)
+	assert.Equal(t, Priority1, item.Priority)
+	// 延迟重试, 到期前不能弹出, 但计入等待中的长度
+	ok, err = q.Retry("p0i0", Priority1, time.Second*2)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = q.Pop()
+	assert.Equal(t, io.EOF, err)
+	waiting, err := q.Length(StateWaiting)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, waiting[Priority1])
+	time.Sleep(time.Second * 3)
+	item, err = q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "p0i0", item.URL)
+	assert.Equal(t, 2, item.Attempts)
+	// 失败后不再弹出, 也不能重复添加
+	ok, err = q.Fail("p0i0", "timeout")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = q.Fail("p0i0", "timeout")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	state, err = q.Lookup("p0i0")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFailed, state)
+	failed, err := q.Length(StateFailed)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failed[Priority1])
+	_, err = q.Pop()
+	assert.Equal(t, io.EOF, err)
+	ok, err = q.Add("p0i0", Priority0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func testQueueRelease(t *testing.T, q Queue) {
+	mustTruncateQueue(t, q)
+	_, err := q.Add("p0i0", Priority0)
+	assert.Nil(t, err)
+	_, err = q.Add("p1i0", Priority1)
+	assert.Nil(t, err)
+	// 等待中的URL不能归还
+	ok, err := q.Release("p0i0")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	item, err := q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "p0i0", item.URL)
+	ok, err = q.Release("p0i0")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// 归还后优先级与失败次数不变, 可立即弹出
+	state, err := q.Lookup("p0i0")
+	assert.Nil(t, err)
+	assert.Equal(t, StateWaiting, state)
+	item, err = q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "p0i0", item.URL)
+	assert.Equal(t, Priority0, item.Priority)
+	assert.Equal(t, 0, item.Attempts)
+}
+
+func testQueueDeadLetter(t *testing.T, q Queue) {
+	assert.Nil(t, q.Truncate())
+	for _, url := range []string{"p0i0", "p0i1", "p1i0"} {
+		_, err := q.Add(url, Priority0)
+		assert.Nil(t, err)
+	}
+	// 依次弹出并置为失败, 失败时间递增
+	for _, reason := range []string{"404", "500", strings.Repeat("错", maxErrorLength+10)} {
+		item, err := q.Pop()
+		assert.Nil(t, err)
+		ok, err := q.Fail(item.Key, reason)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		time.Sleep(time.Millisecond * 10)
+	}
+	// 按失败时间分页列出
+	items, err := q.Failed(0, 2)
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "p0i0", items[0].URL)
+	assert.Equal(t, "404", items[0].LastError)
+	assert.Equal(t, 1, items[0].Attempts)
+	assert.Equal(t, StateFailed, items[0].State)
+	assert.Equal(t, "p0i1", items[1].URL)
+	items, err = q.Failed(2, 2)
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "p1i0", items[0].URL)
+	assert.Equal(t, maxErrorLength, len([]rune(items[0].LastError)))
+	items, err = q.Failed(3, 2)
+	assert.Nil(t, err)
+	assert.Len(t, items, 0)
+	// 导出
+	buf := &bytes.Buffer{}
+	n, err := ExportFailed(q, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `"last_error":"500"`)
+	// 放回指定URL, 失败次数清零
+	n, err = q.Requeue("p0i1", "not_exist")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	item, err := q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "p0i1", item.URL)
+	assert.Equal(t, 0, item.Attempts)
+	assert.Equal(t, "", item.LastError)
+	// 进行中的URL不会被放回
+	n, err = q.Requeue("p0i1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	// 放回全部
+	n, err = q.Requeue()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	failed, err := q.Length(StateFailed)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, failed[Priority0])
+	waiting, err := q.Length(StateWaiting)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, waiting[Priority0])
+}
+
 func TestMySQLQueue_Add(t *testing.T) {
 	testQueueAdd(t, q)
 }
@@ -173,10 +477,83 @@ func TestMySQLQueue_Collect(t *testing.T) {
 	testQueueCollect(t, q, queueTimeout)
 }
 
+func TestMySQLQueue_Touch(t *testing.T) {
+	testQueueTouch(t, q, queueTimeout)
+}
+
+func TestMySQLQueue_Reset(t *testing.T) {
+	testQueueReset(t, q)
+}
+
 func TestMySQLQueue_Lookup_Finish(t *testing.T) {
 	testQueueLookupAndFinish(t, q)
 }
 
+func TestMySQLQueue_Payload(t *testing.T) {
+	testQueuePayload(t, q)
+}
+
+func TestMySQLQueue_Retry_Fail(t *testing.T) {
+	testQueueRetryAndFail(t, q)
+}
+
+func TestMySQLQueue_Release(t *testing.T) {
+	testQueueRelease(t, q)
+}
+
+func TestMySQLQueue_DeadLetter(t *testing.T) {
+	testQueueDeadLetter(t, q)
+}
+
+func TestMySQLQueue_ConcurrentPop(t *testing.T) {
+	testQueueConcurrentPop(t, q)
+}
+
+func TestMySQLQueue_SkipLocked_Pop(t *testing.T) {
+	testQueuePop(t, qSkipLocked)
+}
+
+func TestMySQLQueue_SkipLocked_ConcurrentPop(t *testing.T) {
+	testQueueConcurrentPop(t, qSkipLocked)
+}
+
+func TestMySQLQueue_Migrate(t *testing.T) {
+	db, err := sqlx.Connect("mysql", testDsn+"?parseTime=true")
+	assert.Nil(t, err)
+	defer db.Close()
+	// 旧版本的表结构
+	_, err = db.Exec("DROP TABLE IF EXISTS `queue_legacy`")
+	assert.Nil(t, err)
+	_, err = db.Exec("CREATE TABLE `queue_legacy` (" +
+		"`id`       BIGINT       NOT NULL AUTO_INCREMENT," +
+		"`url`      VARCHAR(500) NOT NULL," +
+		"`state`    TINYINT      NOT NULL," +
+		"`priority` TINYINT      NOT NULL," +
+		"`created`  TIMESTAMP    NOT NULL DEFAULT now()," +
+		"`updated`  TIMESTAMP    NOT NULL DEFAULT now() ON UPDATE now()," +
+		"PRIMARY KEY (`id`)," +
+		"CONSTRAINT unique_url UNIQUE (`url`))")
+	assert.Nil(t, err)
+	_, err = db.Exec("INSERT INTO `queue_legacy` (url, state, priority) VALUE (?, ?, ?)", "http://a.com", StateWaiting, Priority0)
+	assert.Nil(t, err)
+	tmpFile := path.Join(os.TempDir(), fmt.Sprintf("cuckoo-%d.mmap", time.Now().UnixNano()))
+	filter, err := NewCuckooFilter(tmpFile, capacity)
+	assert.Nil(t, err)
+	// 补齐列后旧数据仍可使用, 重复启动不报错
+	for i := 0; i < 2; i++ {
+		legacy, err := NewMyQueue(testDsn, "queue_legacy", filter, queueTimeout)
+		assert.Nil(t, err)
+		state, err := legacy.Lookup("http://a.com")
+		assert.Nil(t, err)
+		assert.Equal(t, StateWaiting, state)
+	}
+	legacy := MustNewMyQueue(testDsn, "queue_legacy", filter, queueTimeout)
+	item, err := legacy.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "http://a.com", item.URL)
+	assert.Equal(t, 0, item.Attempts)
+}
+
 func init() {
 	tmpFile := path.Join(os.TempDir(), fmt.Sprintf("cuckoo-%d.mmap", time.Now().UnixNano()))
 	filter, err := NewCuckooFilter(tmpFile, capacity)
@@ -187,4 +564,9 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	opt := NewMyQueueOpt().SkipLocked(true)
+	qSkipLocked, err = NewMyQueueWithOpt(testDsn, "queue_skip_locked", filter, queueTimeout, opt)
+	if err != nil {
+		panic(err)
+	}
 }
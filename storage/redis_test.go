@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redisCapacity = 1 << 12 // 集合无误判, 无需大量数据
+
+var redisQueue Queue
+var redisBucket Bucket
+var redisFilter Filter
+
+func TestRedisQueue_Add(t *testing.T) {
+	testQueueAdd(t, redisQueue)
+}
+
+func TestRedisQueue_Pop(t *testing.T) {
+	testQueuePop(t, redisQueue)
+}
+
+func TestRedisQueue_Length(t *testing.T) {
+	testQueueLength(t, redisQueue)
+}
+
+func TestRedisQueue_Collect(t *testing.T) {
+	testQueueCollect(t, redisQueue, queueTimeout)
+}
+
+func TestRedisQueue_Touch(t *testing.T) {
+	testQueueTouch(t, redisQueue, queueTimeout)
+}
+
+func TestRedisQueue_Reset(t *testing.T) {
+	testQueueReset(t, redisQueue)
+}
+
+func TestRedisQueue_Lookup_Finish(t *testing.T) {
+	testQueueLookupAndFinish(t, redisQueue)
+}
+
+func TestRedisQueue_Payload(t *testing.T) {
+	testQueuePayload(t, redisQueue)
+}
+
+func TestRedisQueue_Retry_Fail(t *testing.T) {
+	testQueueRetryAndFail(t, redisQueue)
+}
+
+func TestRedisQueue_Release(t *testing.T) {
+	testQueueRelease(t, redisQueue)
+}
+
+func TestRedisQueue_DeadLetter(t *testing.T) {
+	testQueueDeadLetter(t, redisQueue)
+}
+
+func TestRedisQueue_ConcurrentPop(t *testing.T) {
+	testQueueConcurrentPop(t, redisQueue)
+}
+
+func TestRedisQueue_HashTag(t *testing.T) {
+	assert.Equal(t, "{queue}", redisHashTag("queue"))
+	assert.Equal(t, "app:{queue}", redisHashTag("app:{queue}"))
+	assert.Equal(t, "{{}}", redisHashTag("{}"))
+	// 全部key位于同一slot
+	server := miniredis.RunT(t)
+	filter, err := NewRedisFilter("redis://"+server.Addr(), "tagged-filter")
+	assert.Nil(t, err)
+	q, err := NewRedisQueue("redis://"+server.Addr(), "tagged", filter, queueTimeout)
+	assert.Nil(t, err)
+	for _, url := range []string{"a", "b"} {
+		_, err = q.Add(url, Priority0)
+		assert.Nil(t, err)
+	}
+	item, err := q.Pop()
+	assert.Nil(t, err)
+	_, err = q.Retry(item.Key, Priority1, time.Minute)
+	assert.Nil(t, err)
+	keys := server.Keys()
+	assert.NotEmpty(t, keys)
+	for _, key := range keys {
+		assert.True(t, key == "tagged-filter" || strings.HasPrefix(key, "{tagged}:"), key)
+	}
+	assert.Nil(t, q.Truncate())
+	assert.Empty(t, server.Keys())
+}
+
+func TestRedisBucket_Set_Get(t *testing.T) {
+	testBucketSetGet(t, redisBucket)
+}
+
+func TestRedisBucket_Delete(t *testing.T) {
+	testBucketDelete(t, redisBucket)
+}
+
+func TestRedisBucket_Keys(t *testing.T) {
+	testBucketKeys(t, redisBucket)
+}
+
+func TestRedisFilter_Insert(t *testing.T) {
+	testFilterInsert(t, redisFilter, redisCapacity)
+}
+
+func TestRedisFilter_Lookup(t *testing.T) {
+	testFilterLookup(t, redisFilter, redisCapacity)
+}
+
+func TestRedisFilter_Delete_Count(t *testing.T) {
+	testFilterDeleteAndCount(t, redisFilter, redisCapacity)
+}
+
+func init() {
+	// 进程内的Redis替身
+	server, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	redisURL := "redis://" + server.Addr()
+	redisFilter, err = NewRedisFilter(redisURL, "queue-filter")
+	if err != nil {
+		panic(err)
+	}
+	redisQueue, err = NewRedisQueue(redisURL, "queue", redisFilter, queueTimeout)
+	if err != nil {
+		panic(err)
+	}
+	redisBucket, err = NewRedisBucket(redisURL, "bucket")
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// 基于Redis的信息存储, 所有记录保存在名为name的HASH中
+type RedisBucket struct {
+	client *redis.Client
+	name   string
+}
+
+func (r *RedisBucket) Set(key string, value string) error {
+	if err := r.client.HSet(context.Background(), r.name, key, value).Err(); err != nil {
+		return errors.Wrapf(err, "写入记录%q失败", key)
+	}
+	return nil
+}
+
+func (r *RedisBucket) Get(key string) (value string, err error) {
+	value, err = r.client.HGet(context.Background(), r.name, key).Result()
+	if err == redis.Nil {
+		return "", ErrNotExist
+	} else if err != nil {
+		return "", errors.Wrapf(err, "读取记录%q失败", key)
+	}
+	return value, nil
+}
+
+func (r *RedisBucket) Delete(key string) error {
+	n, err := r.client.HDel(context.Background(), r.name, key).Result()
+	if err != nil {
+		return errors.Wrapf(err, "删除记录%q失败", key)
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+func (r *RedisBucket) Keys() ([]string, error) {
+	keys, err := r.client.HKeys(context.Background(), r.name).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询Keys失败")
+	}
+	return keys, nil
+}
+
+func (r *RedisBucket) Truncate() error {
+	if err := r.client.Del(context.Background(), r.name).Err(); err != nil {
+		return errors.Wrapf(err, "清空%q失败", r.name)
+	}
+	return nil
+}
+
+func MustNewRedisBucket(redisURL string, name string) *RedisBucket {
+	bucket, err := NewRedisBucket(redisURL, name)
+	if err != nil {
+		panic(err)
+	}
+	return bucket
+}
+
+// redisURL格式: redis://[:password@]host:port[/db]
+func NewRedisBucket(redisURL string, name string) (*RedisBucket, error) {
+	client, err := connectRedis(redisURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建RedisBucket失败")
+	}
+	return &RedisBucket{client: client, name: name}, nil
+}
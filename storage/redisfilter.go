@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// 基于Redis集合的过滤器, 无误判, 但内存占用随URL数量线性增长
+type RedisFilter struct {
+	client *redis.Client
+	name   string
+}
+
+func (r *RedisFilter) Insert(url string) error {
+	if err := r.client.SAdd(context.Background(), r.name, url).Err(); err != nil {
+		return errors.Wrapf(err, "插入%q失败", url)
+	}
+	return nil
+}
+
+// 删除不存在的URL时，返回ErrNotExist
+func (r *RedisFilter) Delete(url string) error {
+	n, err := r.client.SRem(context.Background(), r.name, url).Result()
+	if err != nil {
+		return errors.Wrapf(err, "删除%q失败", url)
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+func (r *RedisFilter) Lookup(url string) (bool, error) {
+	ok, err := r.client.SIsMember(context.Background(), r.name, url).Result()
+	if err != nil {
+		return false, errors.Wrapf(err, "查询%q失败", url)
+	}
+	return ok, nil
+}
+
+func (r *RedisFilter) Count() (uint, error) {
+	n, err := r.client.SCard(context.Background(), r.name).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "查询数量失败")
+	}
+	return uint(n), nil
+}
+
+func (r *RedisFilter) Truncate() error {
+	if err := r.client.Del(context.Background(), r.name).Err(); err != nil {
+		return errors.Wrapf(err, "清空%q失败", r.name)
+	}
+	return nil
+}
+
+func MustNewRedisFilter(redisURL string, name string) *RedisFilter {
+	filter, err := NewRedisFilter(redisURL, name)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+// redisURL格式: redis://[:password@]host:port[/db]
+func NewRedisFilter(redisURL string, name string) (*RedisFilter, error) {
+	client, err := connectRedis(redisURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建RedisFilter失败")
+	}
+	return &RedisFilter{client: client, name: name}, nil
+}
@@ -0,0 +1,617 @@
+package storage
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key布局, 均以哈希标签{name}为前缀(name中已含哈希标签时直接使用name), 以下简写为name:
+//   name:seq              自增ID
+//   name:priorities       已出现过的优先级, ZSET, score为优先级
+//   name:waiting:P        优先级P中等待中的URL指纹, ZSET, score为ID
+//   name:delayed:P        优先级P中退避中的URL指纹, ZSET, score为可弹出时间(毫秒)
+//   name:processing:P     优先级P中进行中的URL指纹, ZSET, score为被调度时间(毫秒)
+//   name:failed:P         优先级P中已失败的URL指纹, ZSET, score为失败时间(毫秒)
+//   name:item:FP          URL详情, HASH, FP为URL指纹
+//
+// 脚本的ARGV[1]为该前缀, 脚本按优先级或指纹以其拼接key, 这些key未全部声明在KEYS中,
+// 因此仅支持单个Redis节点, 不支持Redis Cluster及按KEYS路由的代理;
+// 哈希标签仅保证全部key位于同一slot
+
+// URL详情的字段, 与parseRedisItem的解析顺序一致
+var redisItemFields = []interface{}{
+	"id", "fp", "url", "state", "priority", "payload", "attempts", "ready", "created", "updated", "error",
+}
+
+// 若URL不存在, 则添加到等待队列
+// KEYS[1]: name:item:FP  KEYS[2]: name:seq  KEYS[3]: name:priorities  KEYS[4]: name:waiting:P
+// ARGV: name, fp, url, priority, payload, now, StateWaiting
+var addScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local id = redis.call('INCR', KEYS[2])
+redis.call('HSET', KEYS[1], 'id', id, 'fp', ARGV[2], 'url', ARGV[3], 'state', ARGV[7], 'priority', ARGV[4],
+	'payload', ARGV[5], 'attempts', 0, 'ready', ARGV[6], 'created', ARGV[6], 'updated', ARGV[6], 'error', '')
+redis.call('ZADD', KEYS[4], id, ARGV[2])
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[4])
+return 1
+`)
+
+// 弹出最优先的URL, 并将其置为进行中; 到期的退避URL先移回等待队列
+// KEYS[1]: name:priorities
+// ARGV: name, now, StateProcessing, 详情字段...
+var popScript = redis.NewScript(`
+for _, p in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	local waiting = ARGV[1] .. ':waiting:' .. p
+	local delayed = ARGV[1] .. ':delayed:' .. p
+	for _, fp in ipairs(redis.call('ZRANGEBYSCORE', delayed, '-inf', ARGV[2])) do
+		local id = redis.call('HGET', ARGV[1] .. ':item:' .. fp, 'id')
+		if id then
+			redis.call('ZADD', waiting, id, fp)
+		end
+		redis.call('ZREM', delayed, fp)
+	end
+	local fps = redis.call('ZRANGE', waiting, 0, 0)
+	if #fps > 0 then
+		local key = ARGV[1] .. ':item:' .. fps[1]
+		redis.call('ZREM', waiting, fps[1])
+		redis.call('ZADD', ARGV[1] .. ':processing:' .. p, ARGV[2], fps[1])
+		redis.call('HSET', key, 'state', ARGV[3], 'updated', ARGV[2])
+		return redis.call('HMGET', key, unpack(ARGV, 4))
+	end
+end
+return false
+`)
+
+// 将早于deadline被弹出的URL放回等待队列, 返回放回前的URL详情
+// KEYS[1]: name:priorities
+// ARGV: name, deadline, now, StateWaiting, 详情字段...
+var collectScript = redis.NewScript(`
+local res = {}
+for _, p in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	local processing = ARGV[1] .. ':processing:' .. p
+	for _, fp in ipairs(redis.call('ZRANGEBYSCORE', processing, '-inf', '(' .. ARGV[2])) do
+		local key = ARGV[1] .. ':item:' .. fp
+		local item = redis.call('HMGET', key, unpack(ARGV, 5))
+		for _, v in ipairs(item) do
+			table.insert(res, v)
+		end
+		redis.call('ZREM', processing, fp)
+		redis.call('HSET', key, 'state', ARGV[4], 'ready', ARGV[3], 'updated', ARGV[3])
+		redis.call('ZADD', ARGV[1] .. ':waiting:' .. p, item[1], fp)
+	end
+end
+return res
+`)
+
+// 从队列中删除URL
+// KEYS[1]: name:item:FP
+// ARGV: name, fp
+var finishScript = redis.NewScript(`
+local p = redis.call('HGET', KEYS[1], 'priority')
+if not p then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+for _, name in ipairs({'waiting', 'delayed', 'processing', 'failed'}) do
+	redis.call('ZREM', ARGV[1] .. ':' .. name .. ':' .. p, ARGV[2])
+end
+return 1
+`)
+
+// 将进行中的URL放回等待队列或退避队列
+// KEYS[1]: name:item:FP  KEYS[2]: name:priorities  KEYS[3]: name:delayed:P  KEYS[4]: name:waiting:P, P为新的优先级
+// ARGV: name, fp, priority, now, ready, StateWaiting, StateProcessing
+var retryScript = redis.NewScript(`
+local item = redis.call('HMGET', KEYS[1], 'state', 'priority', 'id')
+if item[1] ~= ARGV[7] then
+	return 0
+end
+redis.call('ZREM', ARGV[1] .. ':processing:' .. item[2], ARGV[2])
+redis.call('HSET', KEYS[1], 'state', ARGV[6], 'priority', ARGV[3], 'ready', ARGV[5], 'updated', ARGV[4])
+redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+if tonumber(ARGV[5]) > tonumber(ARGV[4]) then
+	redis.call('ZADD', KEYS[3], ARGV[5], ARGV[2])
+else
+	redis.call('ZADD', KEYS[4], item[3], ARGV[2])
+end
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[3])
+return 1
+`)
+
+// 将进行中的URL放回等待队列, 不计失败次数
+// KEYS[1]: name:item:FP
+// ARGV: name, fp, now, StateWaiting, StateProcessing
+var releaseScript = redis.NewScript(`
+local item = redis.call('HMGET', KEYS[1], 'state', 'priority', 'id')
+if item[1] ~= ARGV[5] then
+	return 0
+end
+redis.call('ZREM', ARGV[1] .. ':processing:' .. item[2], ARGV[2])
+redis.call('HSET', KEYS[1], 'state', ARGV[4], 'ready', ARGV[3], 'updated', ARGV[3])
+redis.call('ZADD', ARGV[1] .. ':waiting:' .. item[2], item[3], ARGV[2])
+return 1
+`)
+
+// 将进行中的URL置为失败
+// KEYS[1]: name:item:FP
+// ARGV: name, fp, now, StateFailed, StateProcessing, reason
+var failScript = redis.NewScript(`
+local item = redis.call('HMGET', KEYS[1], 'state', 'priority')
+if item[1] ~= ARGV[5] then
+	return 0
+end
+redis.call('ZREM', ARGV[1] .. ':processing:' .. item[2], ARGV[2])
+redis.call('HSET', KEYS[1], 'state', ARGV[4], 'updated', ARGV[3], 'error', ARGV[6])
+redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+redis.call('ZADD', ARGV[1] .. ':failed:' .. item[2], ARGV[3], ARGV[2])
+return 1
+`)
+
+// 将失败的URL放回等待队列, 未指定指纹时放回全部, 返回放回数量
+// KEYS[1]: name:priorities
+// ARGV: name, now, StateWaiting, StateFailed, fp...
+var requeueScript = redis.NewScript(`
+local fps = {}
+if #ARGV > 4 then
+	for i = 5, #ARGV do
+		table.insert(fps, ARGV[i])
+	end
+else
+	for _, p in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+		for _, fp in ipairs(redis.call('ZRANGE', ARGV[1] .. ':failed:' .. p, 0, -1)) do
+			table.insert(fps, fp)
+		end
+	end
+end
+local n = 0
+for _, fp in ipairs(fps) do
+	local key = ARGV[1] .. ':item:' .. fp
+	local item = redis.call('HMGET', key, 'state', 'priority', 'id')
+	if item[1] == ARGV[4] then
+		redis.call('ZREM', ARGV[1] .. ':failed:' .. item[2], fp)
+		redis.call('HSET', key, 'state', ARGV[3], 'attempts', 0, 'error', '', 'ready', ARGV[2], 'updated', ARGV[2])
+		redis.call('ZADD', ARGV[1] .. ':waiting:' .. item[2], item[3], fp)
+		n = n + 1
+	end
+end
+return n
+`)
+
+// 刷新进行中URL的被调度时间, 返回刷新数量
+// KEYS[1]: name:priorities
+// ARGV: name, now, StateProcessing, fp...
+var touchScript = redis.NewScript(`
+local n = 0
+for i = 4, #ARGV do
+	local key = ARGV[1] .. ':item:' .. ARGV[i]
+	local item = redis.call('HMGET', key, 'state', 'priority')
+	if item[1] == ARGV[3] then
+		redis.call('ZADD', ARGV[1] .. ':processing:' .. item[2], ARGV[2], ARGV[i])
+		redis.call('HSET', key, 'updated', ARGV[2])
+		n = n + 1
+	end
+end
+return n
+`)
+
+// 基于Redis的队列, 每个优先级对应一个有序集合, 适用于多机爬取
+type RedisQueue struct {
+	client      *redis.Client
+	name        string
+	prefix      string // 带哈希标签的key前缀
+	timeout     time.Duration
+	filter      Filter
+	fingerprint Fingerprint
+}
+
+// 为name加上哈希标签, 使队列的全部key位于同一slot
+func redisHashTag(name string) string {
+	if start := strings.Index(name, "{"); start >= 0 {
+		if end := strings.Index(name[start+1:], "}"); end > 0 {
+			return name
+		}
+	}
+	return "{" + name + "}"
+}
+
+func (r *RedisQueue) key(parts ...string) string {
+	k := r.prefix
+	for _, p := range parts {
+		k += ":" + p
+	}
+	return k
+}
+
+func redisItemFieldNames() []string {
+	names := make([]string, len(redisItemFields))
+	for i, f := range redisItemFields {
+		names[i] = f.(string)
+	}
+	return names
+}
+
+// 按redisItemFields的顺序解析HMGET返回的URL详情
+func parseRedisItem(fields []interface{}) (QueueItem, error) {
+	if len(fields) != len(redisItemFields) {
+		return QueueItem{}, errors.Errorf("URL详情字段数量有误: %d", len(fields))
+	}
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		s, ok := f.(string)
+		if !ok {
+			return QueueItem{}, errors.Errorf("URL详情字段有误: %v", fields)
+		}
+		values[i] = s
+	}
+	numbers := make(map[int]int64)
+	for _, i := range []int{0, 3, 4, 6, 7, 8, 9} {
+		v, err := strconv.ParseInt(values[i], 10, 64)
+		if err != nil {
+			return QueueItem{}, errors.Wrapf(err, "URL详情字段有误: %v", fields)
+		}
+		numbers[i] = v
+	}
+	var payload *Payload
+	if values[5] != "" {
+		payload = &Payload{}
+		if err := payload.Scan(values[5]); err != nil {
+			return QueueItem{}, err
+		}
+	}
+	return QueueItem{
+		ID:        numbers[0],
+		Key:       values[1],
+		URL:       values[2],
+		State:     State(numbers[3]),
+		Priority:  Priority(numbers[4]),
+		Payload:   payload,
+		Attempts:  int(numbers[6]),
+		Ready:     time.Unix(0, numbers[7]*int64(time.Millisecond)),
+		Created:   time.Unix(0, numbers[8]*int64(time.Millisecond)),
+		Updated:   time.Unix(0, numbers[9]*int64(time.Millisecond)),
+		LastError: values[10],
+	}, nil
+}
+
+func (r *RedisQueue) priorities(ctx context.Context) ([]string, error) {
+	ps, err := r.client.ZRange(ctx, r.key("priorities"), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "查询优先级失败")
+	}
+	return ps, nil
+}
+
+func (r *RedisQueue) AddDirect(url string, priority Priority) (bool, error) {
+	return r.addDirect(url, priority, nil)
+}
+
+func (r *RedisQueue) addDirect(url string, priority Priority, payload *Payload) (bool, error) {
+	ctx := context.Background()
+	fp := r.fingerprint(url, payload)
+	data := ""
+	if payload != nil {
+		v, err := payload.Value()
+		if err != nil {
+			return false, errors.Wrap(err, "添加URL失败")
+		}
+		data = v.(string)
+	}
+	p := strconv.Itoa(int(priority))
+	keys := []string{r.key("item", fp), r.key("seq"), r.key("priorities"), r.key("waiting", p)}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	n, err := addScript.Run(ctx, r.client, keys, r.prefix, fp, url, int(priority), data, now, int(StateWaiting)).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "添加URL失败")
+	}
+	return n == 1, nil // n==0时, 队列中重复
+}
+
+func (r *RedisQueue) Add(url string, priority Priority) (bool, error) {
+	return r.AddPayload(url, priority, nil)
+}
+
+func (r *RedisQueue) AddPayload(url string, priority Priority, payload *Payload) (bool, error) {
+	exist, err := r.filter.Lookup(r.fingerprint(url, payload))
+	if err != nil {
+		return false, errors.Wrapf(err, "添加URL时在Filter中查询失败")
+	}
+	if exist {
+		return false, nil
+	}
+	return r.addDirect(url, priority, payload)
+}
+
+func (r *RedisQueue) SetFingerprint(f Fingerprint) {
+	r.fingerprint = f
+}
+
+func (r *RedisQueue) Pop() (item QueueItem, err error) {
+	ctx := context.Background()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	args := append([]interface{}{r.prefix, now, int(StateProcessing)}, redisItemFields...)
+	res, err := popScript.Run(ctx, r.client, []string{r.key("priorities")}, args...).Slice()
+	if err == redis.Nil {
+		return QueueItem{}, io.EOF
+	} else if err != nil {
+		return QueueItem{}, errors.Wrap(err, "弹出URL失败")
+	}
+	return parseRedisItem(res)
+}
+
+func (r *RedisQueue) Length(state State) (map[Priority]int, error) {
+	ctx := context.Background()
+	var names []string
+	switch state {
+	case StateWaiting:
+		names = []string{"waiting", "delayed"}
+	case StateProcessing:
+		names = []string{"processing"}
+	case StateFailed:
+		names = []string{"failed"}
+	default:
+		return map[Priority]int{}, nil
+	}
+	ps, err := r.priorities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[Priority]int)
+	for _, p := range ps {
+		n := int64(0)
+		for _, name := range names {
+			c, err := r.client.ZCard(ctx, r.key(name, p)).Result()
+			if err != nil {
+				return nil, errors.Wrap(err, "查询长度信息失败")
+			}
+			n += c
+		}
+		if n == 0 {
+			continue
+		}
+		priority, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "优先级%q格式有误", p)
+		}
+		res[Priority(priority)] = int(n)
+	}
+	return res, nil
+}
+
+func (r *RedisQueue) Truncate() error {
+	ctx := context.Background()
+	iter := r.client.Scan(ctx, 0, r.prefix+":*", 512).Iterator()
+	keys := make([]string, 0)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) >= 512 {
+			if err := r.client.Del(ctx, keys...).Err(); err != nil {
+				return errors.Wrapf(err, "清空%q失败", r.name)
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return errors.Wrapf(err, "清空%q失败", r.name)
+	}
+	if len(keys) > 0 {
+		if err := r.client.Del(ctx, keys...).Err(); err != nil {
+			return errors.Wrapf(err, "清空%q失败", r.name)
+		}
+	}
+	if err := r.filter.Truncate(); err != nil {
+		return errors.Wrapf(err, "清空过滤器失败")
+	}
+	return nil
+}
+
+func (r *RedisQueue) Collect() ([]QueueItem, error) {
+	return r.collect(time.Now().Add(-r.timeout))
+}
+
+func (r *RedisQueue) Reset() (int, error) {
+	items, err := r.collect(time.Now().Add(time.Millisecond))
+	if err != nil {
+		return 0, errors.Wrap(err, "重置进行中的URL失败")
+	}
+	return len(items), nil
+}
+
+// 将早于deadline被弹出的URL放回等待队列
+func (r *RedisQueue) collect(deadline time.Time) ([]QueueItem, error) {
+	ctx := context.Background()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	args := append([]interface{}{
+		r.prefix, deadline.UnixNano() / int64(time.Millisecond), now, int(StateWaiting),
+	}, redisItemFields...)
+	res, err := collectScript.Run(ctx, r.client, []string{r.key("priorities")}, args...).Slice()
+	if err != nil {
+		return nil, errors.Wrap(err, "放回过期数据失败")
+	}
+	items := make([]QueueItem, 0)
+	n := len(redisItemFields)
+	for i := 0; i+n <= len(res); i += n {
+		item, err := parseRedisItem(res[i : i+n])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+func (r *RedisQueue) Finish(key string) (bool, error) {
+	// 添加到过滤器
+	if err := r.filter.Insert(key); err != nil {
+		return false, errors.Wrap(err, "添加到Filter失败")
+	}
+	// 从队列中删除
+	n, err := finishScript.Run(context.Background(), r.client, []string{r.key("item", key)}, r.prefix, key).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "更新数据失败")
+	}
+	return n == 1, nil
+}
+
+func (r *RedisQueue) Retry(key string, priority Priority, delay time.Duration) (bool, error) {
+	now := time.Now()
+	ready := now.Add(delay).UnixNano() / int64(time.Millisecond)
+	p := strconv.Itoa(int(priority))
+	keys := []string{r.key("item", key), r.key("priorities"), r.key("delayed", p), r.key("waiting", p)}
+	args := []interface{}{
+		r.prefix, key, int(priority), now.UnixNano() / int64(time.Millisecond), ready,
+		int(StateWaiting), int(StateProcessing),
+	}
+	n, err := retryScript.Run(context.Background(), r.client, keys, args...).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "重试URL失败")
+	}
+	return n == 1, nil
+}
+
+func (r *RedisQueue) Release(key string) (bool, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	args := []interface{}{r.prefix, key, now, int(StateWaiting), int(StateProcessing)}
+	n, err := releaseScript.Run(context.Background(), r.client, []string{r.key("item", key)}, args...).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "归还URL失败")
+	}
+	return n == 1, nil
+}
+
+func (r *RedisQueue) Fail(key string, reason string) (bool, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	args := []interface{}{r.prefix, key, now, int(StateFailed), int(StateProcessing), truncateReason(reason)}
+	n, err := failScript.Run(context.Background(), r.client, []string{r.key("item", key)}, args...).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "更新URL状态失败")
+	}
+	return n == 1, nil
+}
+
+func (r *RedisQueue) Failed(offset, limit int) ([]QueueItem, error) {
+	ctx := context.Background()
+	ps, err := r.priorities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 合并各优先级的失败集合, 按失败时间排序后分页
+	members := make([]redis.Z, 0)
+	for _, p := range ps {
+		zs, err := r.client.ZRangeWithScores(ctx, r.key("failed", p), 0, -1).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "查询失败的URL失败")
+		}
+		members = append(members, zs...)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member.(string) < members[j].Member.(string)
+	})
+	items := make([]QueueItem, 0)
+	if offset >= len(members) {
+		return items, nil
+	}
+	members = members[offset:]
+	if limit < len(members) {
+		members = members[:limit]
+	}
+	for _, m := range members {
+		fields, err := r.client.HMGet(ctx, r.key("item", m.Member.(string)), redisItemFieldNames()...).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "查询失败的URL失败")
+		}
+		item, err := parseRedisItem(fields)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (r *RedisQueue) Touch(keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	args := []interface{}{r.prefix, now, int(StateProcessing)}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	n, err := touchScript.Run(context.Background(), r.client, []string{r.key("priorities")}, args...).Int()
+	if err != nil {
+		return 0, errors.Wrap(err, "刷新进行中的URL失败")
+	}
+	return n, nil
+}
+
+func (r *RedisQueue) Requeue(keys ...string) (int, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	args := []interface{}{r.prefix, now, int(StateWaiting), int(StateFailed)}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	n, err := requeueScript.Run(context.Background(), r.client, []string{r.key("priorities")}, args...).Int()
+	if err != nil {
+		return 0, errors.Wrap(err, "放回失败的URL失败")
+	}
+	return n, nil
+}
+
+func (r *RedisQueue) Lookup(key string) (State, error) {
+	state, err := r.client.HGet(context.Background(), r.key("item", key), "state").Int()
+	if err == redis.Nil {
+		return StateNotExist, nil
+	} else if err != nil {
+		return StateNotExist, errors.Wrap(err, "查询url状态失败")
+	}
+	return State(state), nil
+}
+
+func MustNewRedisQueue(redisURL string, name string, filter Filter, timeout time.Duration) Queue {
+	q, err := NewRedisQueue(redisURL, name, filter, timeout)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// redisURL格式: redis://[:password@]host:port[/db], 仅支持单个Redis节点
+func NewRedisQueue(redisURL string, name string, filter Filter, timeout time.Duration) (Queue, error) {
+	client, err := connectRedis(redisURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建RedisQueue失败")
+	}
+	queue := &RedisQueue{
+		client:      client,
+		name:        name,
+		prefix:      redisHashTag(name),
+		filter:      filter,
+		timeout:     timeout,
+		fingerprint: DefaultFingerprint,
+	}
+	return queue, nil
+}
+
+func connectRedis(redisURL string) (*redis.Client, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "解析%q失败", redisURL)
+	}
+	client := redis.NewClient(opt)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, errors.Wrapf(err, "连接%q失败", opt.Addr)
+	}
+	return client, nil
+}
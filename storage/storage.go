@@ -44,27 +44,43 @@ const (
 	StateNotExist   State = iota // URL未在队列中
 	StateWaiting                 // URL正在队列中
 	StateProcessing              // URL已被调度
+	StateFailed                  // URL重试次数耗尽, 不再被调度
 )
 
 // 队列中的URL
 type QueueItem struct {
-	ID       int64     `db:"id"`
-	URL      string    `db:"url"`
-	State    State     `db:"state"`
-	Priority Priority  `db:"priority"`
-	Created  time.Time `db:"created"`
-	Updated  time.Time `db:"updated"`
-	Count    int       `db:"count"` // 用于SQL查询统计数量
+	ID        int64     `db:"id"`
+	Key       string    `db:"fp"` // URL指纹, 未携带附加信息时即为URL
+	URL       string    `db:"url"`
+	State     State     `db:"state"`
+	Priority  Priority  `db:"priority"`
+	Payload   *Payload  `db:"payload"`    // 附加信息, 可为nil
+	Attempts  int       `db:"attempts"`   // 已失败次数
+	LastError string    `db:"last_error"` // 最后一次失败的错误信息, 仅失败状态时记录
+	Ready     time.Time `db:"ready"`      // 早于该时间时不会被弹出, 用于重试退避
+	Created   time.Time `db:"created"`
+	Updated   time.Time `db:"updated"`
+	Count     int       `db:"count"` // 用于SQL查询统计数量
 }
 
 // 队列, 保存等待中,进行中的URL
+// 队列与Filter均以URL指纹(QueueItem.Key)去重
 type Queue interface {
-	AddDirect(url string, priority Priority) (bool, error) // 添加URL到队列中
-	Add(url string, priority Priority) (bool, error)       // 若URL未在Filter中，则添加URL到队列中
-	Pop() (item QueueItem, err error)                      // 弹出最优先URL, 无URL时返回io.EOF
-	Length(state State) (map[Priority]int, error)          // 获取各优先级的队列长度
-	Truncate() error                                       // 清空队列
-	Collect() ([]QueueItem, error)                         // 清理超时的URL
-	Finish(url string) (bool, error)                       // 报告URL已完成
-	Lookup(url string) (State, error)                      // URL是否存在
+	AddDirect(url string, priority Priority) (bool, error)                    // 添加URL到队列中
+	Add(url string, priority Priority) (bool, error)                          // 若URL未在Filter中，则添加URL到队列中
+	AddPayload(url string, priority Priority, payload *Payload) (bool, error) // 同Add, 并携带附加信息
+	SetFingerprint(f Fingerprint)                                             // 设置指纹算法, 默认为DefaultFingerprint
+	Pop() (item QueueItem, err error)                                         // 弹出最优先URL, 无URL或均在退避中时返回io.EOF
+	Length(state State) (map[Priority]int, error)                             // 获取各优先级的队列长度
+	Truncate() error                                                          // 清空队列
+	Collect() ([]QueueItem, error)                                            // 将超时的进行中URL放回等待队列, 优先级不变, 返回放回前的URL
+	Reset() (int, error)                                                      // 将全部进行中的URL放回等待队列, 返回放回数量
+	Touch(keys ...string) (int, error)                                        // 刷新进行中URL的超时计时, 使其不被Collect放回, 返回刷新数量
+	Finish(key string) (bool, error)                                          // 报告URL已完成, key为URL指纹
+	Retry(key string, priority Priority, delay time.Duration) (bool, error)   // 将进行中的URL放回队列, 失败次数加1, delay后才可弹出
+	Release(key string) (bool, error)                                         // 将进行中的URL放回队列, 不计失败次数, 用于中止时归还未完成的URL
+	Fail(key string, reason string) (bool, error)                             // 将进行中的URL置为失败, 失败次数加1, 并记录错误信息
+	Failed(offset, limit int) ([]QueueItem, error)                            // 按失败时间(Updated)升序列出失败的URL
+	Requeue(keys ...string) (int, error)                                      // 将失败的URL放回队列并清零失败次数, keys为空时放回全部
+	Lookup(key string) (State, error)                                         // URL是否存在, key为URL指纹
 }
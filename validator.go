@@ -0,0 +1,192 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spencer404/go-digger/proxy"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 代理校验参数, 新代理通过校验后才进入代理池; RecheckInterval大于0时定期重新校验代理池中空闲的代理
+type ProxyValidator struct {
+	URL             string        // 探测地址, 通过代理请求该地址
+	Status          int           // 期望的状态码, 默认200
+	Contains        string        // 响应中应包含的内容, 为空时不检查
+	Timeout         time.Duration // 单次校验的超时时间, 默认10秒
+	Concurrency     int           // 同时校验的代理数量, 默认8
+	RecheckInterval time.Duration // 重新校验空闲代理的间隔, 为0时不重新校验
+}
+
+func (v ProxyValidator) withDefaults() ProxyValidator {
+	if v.Status == 0 {
+		v.Status = http.StatusOK
+	}
+	if v.Timeout == 0 {
+		v.Timeout = time.Second * 10
+	}
+	if v.Concurrency < 1 {
+		v.Concurrency = 8
+	}
+	return v
+}
+
+// 响应中查找Contains时最多读取的字节数
+const validateBodyLimit = 1 << 20
+
+// 通过client请求探测地址, 状态码或内容不符时返回错误
+func (v *ProxyValidator) check(ctx context.Context, client *http.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, v.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.URL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "探测地址格式错误: %s", v.URL)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "请求探测地址失败")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != v.Status {
+		return errors.Errorf("探测地址返回异常状态码: %d", resp.StatusCode)
+	}
+	if v.Contains == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, validateBodyLimit))
+	if err != nil {
+		return errors.Wrap(err, "读取探测地址响应失败")
+	}
+	if !strings.Contains(string(body), v.Contains) {
+		return errors.Errorf("探测地址响应中未包含%q", v.Contains)
+	}
+	return nil
+}
+
+// 校验代理, 失败时移除其http.Client
+func (r *Reactor) validateProxy(ctx context.Context, url string) error {
+	client, err := r.clients.Get(url)
+	if err != nil {
+		return err
+	}
+	if err := r.validator.check(ctx, client); err != nil {
+		r.clients.Remove(url)
+		return err
+	}
+	return nil
+}
+
+// 启动校验新代理的Goroutine, 通过校验的代理进入pool, 未通过的从poolFilter中移除
+func (r *Reactor) startValidator(ctx context.Context) chan<- []*Proxy {
+	ch := make(chan []*Proxy)
+	for i := 0; i < r.validator.Concurrency; i++ {
+		go func() {
+			for {
+				var ps []*Proxy
+				select {
+				case ps = <-ch:
+				case <-ctx.Done():
+					return
+				}
+				url := ps[0].URL
+				if err := r.validateProxy(ctx, url); err != nil {
+					r.log.Debug("新代理未通过校验", "proxy", proxy.Redact(url), "error", err)
+					r.discardProxies(ps)
+					continue
+				}
+				now := time.Now()
+				for i, p := range ps {
+					p.CheckedTime = now
+					if !r.pool.push(ctx, p) {
+						// 未入队的代理可被再次提供
+						r.discardProxies(ps[i:])
+						return
+					}
+					r.log.Debug("新代理已入队", "proxy", proxy.Redact(url), "index", p.Index)
+				}
+			}
+		}()
+	}
+	return ch
+}
+
+// 定期重新校验pool中空闲的代理, 通过的放回暂存区, 未通过的丢弃
+func (r *Reactor) startRechecker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.validator.RecheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			r.recheckProxies(ctx)
+		}
+	}()
+}
+
+func (r *Reactor) recheckProxies(ctx context.Context) {
+	// 取出超过RecheckInterval未校验的代理, 按地址分组
+	groups := make(map[string][]*Proxy)
+	keep := make([]*Proxy, 0)
+	r.poolLock.Lock()
+	for _, p := range r.pool.drain() {
+		if time.Since(p.CheckedTime) < r.validator.RecheckInterval {
+			keep = append(keep, p)
+			continue
+		}
+		groups[p.URL] = append(groups[p.URL], p)
+	}
+	r.poolLock.Unlock()
+	r.putBack(keep...)
+	if len(groups) == 0 {
+		return
+	}
+	r.log.Debug("正在重新校验空闲代理", "count", len(groups))
+	sem := make(chan struct{}, r.validator.Concurrency)
+	var wg sync.WaitGroup
+	for url, ps := range groups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(url string, ps []*Proxy) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := r.validateProxy(ctx, url); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				r.log.Info("空闲代理未通过校验, 已丢弃", "proxy", proxy.Redact(url), "error", err)
+				r.discardProxies(ps)
+				return
+			}
+			now := time.Now()
+			for _, p := range ps {
+				p.CheckedTime = now
+			}
+			r.putBack(ps...)
+		}(url, ps)
+	}
+	wg.Wait()
+}
+
+// 丢弃代理, 之后Provider再次提供时可重新入队
+func (r *Reactor) discardProxies(ps []*Proxy) {
+	for _, p := range ps {
+		r.poolFilter.Remove(fmt.Sprintf("%s:%d", p.URL, p.Index))
+	}
+}
+
+// 将代理放回暂存区
+func (r *Reactor) putBack(ps ...*Proxy) {
+	if len(ps) == 0 {
+		return
+	}
+	r.putBackLock.Lock()
+	r.putBackList = append(r.putBackList, ps...)
+	r.putBackLock.Unlock()
+}
@@ -0,0 +1,184 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	mapset "github.com/deckarep/golang-set"
+	"github.com/go-resty/resty/v2"
+	"github.com/spencer404/go-digger/proxy"
+	"github.com/spencer404/go-digger/storage"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newValidatorTarget() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/slow":
+			time.Sleep(time.Millisecond * 300)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+}
+
+// 转发请求的HTTP代理, alive为0时返回502
+func newTestProxy(alive *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(alive) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		out, err := http.NewRequest(req.Method, req.URL.String(), req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+}
+
+func TestProxyValidator_check(t *testing.T) {
+	target := newValidatorTarget()
+	defer target.Close()
+	ctx := context.Background()
+	client := &http.Client{}
+	v := ProxyValidator{URL: target.URL, Contains: "ok", Timeout: time.Millisecond * 100}.withDefaults()
+	assert.Nil(t, v.check(ctx, client))
+	v.URL = target.URL + "/404"
+	assert.NotNil(t, v.check(ctx, client))
+	v.Status = http.StatusNotFound
+	assert.Nil(t, v.check(ctx, client))
+	v.Contains = "ko"
+	assert.NotNil(t, v.check(ctx, client))
+	v = ProxyValidator{URL: target.URL + "/slow", Timeout: time.Millisecond * 100}.withDefaults()
+	assert.NotNil(t, v.check(ctx, client))
+}
+
+func TestReactor_Run_ValidateProxies(t *testing.T) {
+	target := newValidatorTarget()
+	defer target.Close()
+	alive := int32(1)
+	good := newTestProxy(&alive)
+	defer good.Close()
+	dead := int32(0)
+	bad := newTestProxy(&dead)
+	defer bad.Close()
+	// 持续提供代理, 使暂存区中的代理得以入队
+	provider := make(chan proxy.Item)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for _, url := range []string{"http://127.0.0.1:1", bad.URL} {
+			provider <- proxy.Item{URL: url, EnableFilter: true}
+		}
+		for {
+			select {
+			case provider <- proxy.Item{URL: good.URL, EnableFilter: true}:
+			case <-done:
+				return
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+	}()
+	var lock sync.Mutex
+	used := make(map[string]int)
+	opt := NewReactorOpt().
+		ProxyProviders(provider).
+		Retry(0).
+		ValidateProxies(ProxyValidator{URL: target.URL, Contains: "ok", Timeout: time.Second}).
+		Use(Middleware{
+			BeforeRequest: func(hc *HookContext, req *resty.Request) error {
+				lock.Lock()
+				used[hc.Proxy]++
+				lock.Unlock()
+				return nil
+			},
+		})
+	r := newTestReactor(t, 1, opt)
+	spider := &Spider{
+		Seeders: []string{target.URL + "/a", target.URL + "/b", target.URL + "/c"},
+		OnProcess: func(item *storage.QueueItem, client *resty.Client, proxy *ProxyHelper, reactor *Reactor) error {
+			_, err := client.R().Get(item.URL)
+			return err
+		},
+	}
+	assert.Nil(t, r.Run(spider))
+	// 只使用了通过校验的代理
+	assert.Equal(t, map[string]int{good.URL: 3}, used)
+	lengths, err := r.Queue.Length(storage.StateFailed)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, lengths[storage.Priority0])
+	// 未通过的代理可被再次提供
+	assert.False(t, r.poolFilter.Contains(bad.URL+":0"))
+	assert.True(t, r.poolFilter.Contains(good.URL+":0"))
+}
+
+func TestReactor_recheckProxies(t *testing.T) {
+	target := newValidatorTarget()
+	defer target.Close()
+	alive1, alive2 := int32(1), int32(1)
+	proxy1, proxy2 := newTestProxy(&alive1), newTestProxy(&alive2)
+	defer proxy1.Close()
+	defer proxy2.Close()
+	r := newTestReactor(t, 1, NewReactorOpt().ValidateProxies(ProxyValidator{URL: target.URL, RecheckInterval: time.Minute}))
+	r.pool = newProxyPool(4, LeastRecentlyUsed())
+	r.putBackList = make([]*Proxy, 0)
+	r.poolFilter = mapset.NewSet()
+	// proxy2失效, proxy1最近已校验
+	atomic.StoreInt32(&alive2, 0)
+	ps := []*Proxy{
+		{URL: proxy1.URL, Index: 0, CheckedTime: time.Now()},
+		{URL: proxy1.URL, Index: 1},
+		{URL: proxy2.URL, Index: 0},
+	}
+	for _, p := range ps {
+		r.poolFilter.Add(fmt.Sprintf("%s:%d", p.URL, p.Index))
+		assert.True(t, r.pool.push(context.Background(), p))
+	}
+	r.recheckProxies(context.Background())
+	assert.Equal(t, 0, r.pool.Len())
+	assert.ElementsMatch(t, ps[:2], r.putBackList)
+	assert.False(t, ps[1].CheckedTime.IsZero())
+	assert.False(t, r.poolFilter.Contains(proxy2.URL+":0"))
+	assert.True(t, r.poolFilter.Contains(proxy1.URL+":1"))
+}
+
+func TestReactor_startValidator_Cancel(t *testing.T) {
+	target := newValidatorTarget()
+	defer target.Close()
+	alive := int32(1)
+	good := newTestProxy(&alive)
+	defer good.Close()
+	r := newTestReactor(t, 1, NewReactorOpt().ValidateProxies(ProxyValidator{URL: target.URL}))
+	// 代理池已满, 通过校验的代理阻塞在入队
+	r.pool = newProxyPool(1, LeastRecentlyUsed())
+	r.poolFilter = mapset.NewSet()
+	assert.True(t, r.pool.push(context.Background(), &Proxy{URL: "http://127.0.0.1:1"}))
+	ps := []*Proxy{{URL: good.URL, Index: 0}, {URL: good.URL, Index: 1}}
+	for _, p := range ps {
+		r.poolFilter.Add(fmt.Sprintf("%s:%d", p.URL, p.Index))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.startValidator(ctx) <- ps
+	time.Sleep(time.Millisecond * 200)
+	cancel()
+	// 未入队的代理可被再次提供
+	assert.Eventually(t, func() bool {
+		return !r.poolFilter.Contains(good.URL+":0") && !r.poolFilter.Contains(good.URL+":1")
+	}, time.Second, time.Millisecond*10)
+}